@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"strconv"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -71,3 +72,19 @@ func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http
 	message := "ratelimit exceeded response"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
+
+func (app *application) serverBusyResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(app.config.runner.retryAfter))
+	message := "the server is busy running other code, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+func (app *application) tooManySessionsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you have too many open sessions, delete one before creating another"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+func (app *application) sessionBusyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the session is still evaluating a previous cell"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
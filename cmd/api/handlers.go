@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -9,6 +8,7 @@ import (
 	"time"
 
 	"github.com/VJ-2303/code-runner/internal/data"
+	"github.com/VJ-2303/code-runner/internal/runner"
 	"github.com/VJ-2303/code-runner/internal/validator"
 )
 
@@ -29,9 +29,12 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 
 func (app *application) createSnippetHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title    string `json:"title"`
-		Content  string `json:"content"`
-		Language string `json:"language"`
+		Title      string        `json:"title"`
+		Content    string        `json:"content"`
+		Language   string        `json:"language"`
+		Version    string        `json:"language_version"`
+		Files      []runner.File `json:"files"`
+		EntryPoint string        `json:"entry_point"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -43,15 +46,21 @@ func (app *application) createSnippetHandler(w http.ResponseWriter, r *http.Requ
 	user := contextGetUser(r)
 
 	snippet := &data.Snippet{
-		Title:     input.Title,
-		UserID:    user.ID,
-		Content:   input.Content,
-		Language:  input.Language,
-		ExpiresAt: time.Now().Add(7 * 24 * time.Hour),
+		Title:           input.Title,
+		UserID:          user.ID,
+		Content:         input.Content,
+		Language:        input.Language,
+		LanguageVersion: input.Version,
+		Files:           input.Files,
+		EntryPoint:      app.entryPoint(input.Language, input.Files, input.EntryPoint),
+		ExpiresAt:       time.Now().Add(7 * 24 * time.Hour),
 	}
 
 	v := validator.New()
-	data.ValidateSnippet(v, snippet)
+	data.ValidateSnippet(v, snippet, app.languages.IDs())
+	if lang, ok := app.languages.Get(snippet.Language); ok {
+		runner.ValidateVersion(v, lang, snippet.LanguageVersion)
+	}
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.FieldErrors)
 		return
@@ -71,36 +80,8 @@ func (app *application) createSnippetHandler(w http.ResponseWriter, r *http.Requ
 	}
 }
 
-func (app *application) runCodeHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Code     string `json:"code"`
-		Language string `json:"language"`
-	}
-
-	err := app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
-	}
-
-	v := validator.New()
-
-	v.Check(input.Code != "", "code", "must be provided")
-	v.Check(validator.PermittedValue(input.Language, "ruby", "python", "javascript"), "language", "must be either go, python or javascript")
-
-	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.FieldErrors)
-		return
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	result, err := app.runner.Run(ctx, input.Code, input.Language)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"result": result}, nil)
+func (app *application) listLanguagesHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"languages": app.languages.All()}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -116,7 +97,7 @@ func (app *application) GetAllSnippetHandler(w http.ResponseWriter, r *http.Requ
 		PageSize: app.readInt(qs, "page_size", 5, v),
 		Page:     app.readInt(qs, "page", 1, v),
 	}
-	data.ValidateFilters(v, f)
+	data.ValidateFilters(v, f, app.languages.IDs())
 
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.FieldErrors)
@@ -188,9 +169,12 @@ func (app *application) updateSnippetHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	var input struct {
-		Title    *string `json:"title"`
-		Language *string `json:"language"`
-		Content  *string `json:"content"`
+		Title      *string        `json:"title"`
+		Language   *string        `json:"language"`
+		Version    *string        `json:"language_version"`
+		Content    *string        `json:"content"`
+		Files      *[]runner.File `json:"files"`
+		EntryPoint *string        `json:"entry_point"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -206,10 +190,27 @@ func (app *application) updateSnippetHandler(w http.ResponseWriter, r *http.Requ
 		snippet.Content = *input.Content
 	}
 	if input.Language != nil {
+		// A version of the old language means nothing to the new one.
+		if *input.Language != snippet.Language {
+			snippet.LanguageVersion = ""
+		}
 		snippet.Language = *input.Language
 	}
+	if input.Version != nil {
+		snippet.LanguageVersion = *input.Version
+	}
+	if input.Files != nil {
+		snippet.Files = *input.Files
+	}
+	if input.EntryPoint != nil {
+		snippet.EntryPoint = *input.EntryPoint
+	}
+	snippet.EntryPoint = app.entryPoint(snippet.Language, snippet.Files, snippet.EntryPoint)
 	v := validator.New()
-	data.ValidateSnippet(v, snippet)
+	data.ValidateSnippet(v, snippet, app.languages.IDs())
+	if lang, ok := app.languages.Get(snippet.Language); ok {
+		runner.ValidateVersion(v, lang, snippet.LanguageVersion)
+	}
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.FieldErrors)
 		return
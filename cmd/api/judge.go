@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/VJ-2303/code-runner/internal/data"
+	"github.com/VJ-2303/code-runner/internal/judge"
+	"github.com/VJ-2303/code-runner/internal/runner"
+	"github.com/VJ-2303/code-runner/internal/validator"
+)
+
+func (app *application) judgeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code       string            `json:"code"`
+		Language   string            `json:"language"`
+		Version    string            `json:"language_version"`
+		Files      []runner.File     `json:"files"`
+		EntryPoint string            `json:"entry_point"`
+		Args       []string          `json:"args"`
+		Env        map[string]string `json:"env"`
+		Cases      []judge.TestCase  `json:"cases"`
+		Mode       judge.Mode        `json:"mode"`
+		Tolerance  float64           `json:"tolerance"`
+		// The time limit applies to the cases without one of their own.
+		limitsInput
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Mode == "" {
+		input.Mode = judge.ModeExact
+	}
+
+	user := contextGetUser(r)
+
+	req := judge.Request{
+		ExecuteRequest: runner.ExecuteRequest{
+			UserID:     user.ID,
+			Code:       input.Code,
+			Language:   input.Language,
+			Version:    input.Version,
+			Files:      input.Files,
+			EntryPoint: app.entryPoint(input.Language, input.Files, input.EntryPoint),
+			Args:       input.Args,
+			Env:        input.Env,
+			Limits:     input.limits(),
+		},
+		Cases:     input.Cases,
+		Mode:      input.Mode,
+		Tolerance: input.Tolerance,
+	}
+
+	v := validator.New()
+
+	data.ValidateLanguage(v, input.Language, app.languages.IDs())
+	lang, ok := app.languages.Get(input.Language)
+	if ok {
+		runner.ValidateDependencies(v, lang, req.Files)
+		runner.ValidateVersion(v, lang, req.Version)
+
+		caps := lang.LimitCaps(app.config.tiers.Get(user.Tier))
+		judge.ValidateRequest(v, req, caps)
+		req.Limits = lang.EffectiveLimits(req.Limits, caps)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.FieldErrors)
+		return
+	}
+
+	// The cases run one after another, which easily outlasts the server's
+	// write timeout.
+	err = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(judgeDuration(lang, req)))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	result, err := app.judge.Run(r.Context(), req)
+	if err != nil {
+		if errors.Is(err, runner.ErrPoolFull) {
+			app.serverBusyResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"judge": result}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// judgeDuration is an upper bound for judging the cases of req in lang,
+// leaving a minute for waiting on execution slots.
+func judgeDuration(lang runner.Language, req judge.Request) time.Duration {
+	d := time.Minute
+	if lang.Dependencies != nil {
+		d += time.Duration(lang.Dependencies.InstallLimits.Timeout)
+	}
+	for _, c := range req.Cases {
+		if lang.Compiled() {
+			d += time.Duration(lang.CompileLimits.Timeout)
+		}
+		if c.TimeLimitMS > 0 {
+			d += time.Duration(c.TimeLimitMS) * time.Millisecond
+		} else {
+			d += time.Duration(req.Limits.Timeout)
+		}
+	}
+	return d
+}
@@ -1,6 +1,10 @@
 package main
 
-import "net/http"
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+)
 
 func (app *application) router() http.Handler {
 	mux := http.NewServeMux()
@@ -15,7 +19,17 @@ func (app *application) router() http.Handler {
 	mux.HandleFunc("POST /v1/snippets/share/{id}", app.requireAuthenticatedUser(app.createShareTokenHandler))
 	mux.HandleFunc("GET /v1/snippets/share/{token}", app.getSharedSnippetHandler)
 
+	mux.HandleFunc("GET /v1/languages", app.listLanguagesHandler)
 	mux.HandleFunc("POST /v1/run", app.requireAuthenticatedUser(app.runCodeHandler))
+	mux.HandleFunc("POST /v1/runs", app.requireAuthenticatedUser(app.createRunJobHandler))
+	mux.HandleFunc("GET /v1/runs/{id}", app.requireAuthenticatedUser(app.getRunJobHandler))
+	mux.HandleFunc("GET /v1/runs/{id}/events", app.requireAuthenticatedUser(app.runEventsHandler))
+	mux.HandleFunc("POST /v1/judge", app.requireAuthenticatedUser(app.judgeHandler))
+	mux.HandleFunc("POST /v1/test", app.requireAuthenticatedUser(app.runTestsHandler))
+	mux.HandleFunc("GET /v1/terminal", app.requireAuthenticatedUser(app.terminalHandler))
+	mux.HandleFunc("POST /v1/sessions", app.requireAuthenticatedUser(app.createSessionHandler))
+	mux.HandleFunc("POST /v1/sessions/{id}/eval", app.requireAuthenticatedUser(app.evalSessionHandler))
+	mux.HandleFunc("DELETE /v1/sessions/{id}", app.requireAuthenticatedUser(app.deleteSessionHandler))
 
 	mux.HandleFunc("POST /v1/users", app.registerUserHandler)
 	mux.HandleFunc("DELETE /v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
@@ -25,3 +39,34 @@ func (app *application) router() http.Handler {
 
 	return app.rateLimit(app.enableCORS(app.authenticate(mux)))
 }
+
+// metricsVars are the expvar variables served by metricsRouter. Everything
+// else expvar knows about, such as cmdline with the database, Redis and SMTP
+// passwords, is left out.
+var metricsVars = []string{"runner", "warm_pool"}
+
+// metricsRouter serves the runner and queue counters in expvar's format. It
+// is meant for the internal metrics listener only, never the public API.
+func (app *application) metricsRouter() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /debug/vars", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, "{")
+		first := true
+		for _, name := range metricsVars {
+			v := expvar.Get(name)
+			if v == nil {
+				continue
+			}
+			if !first {
+				fmt.Fprint(w, ",")
+			}
+			first = false
+			fmt.Fprintf(w, "\n%q: %s", name, v)
+		}
+		fmt.Fprint(w, "\n}\n")
+	})
+
+	return mux
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/VJ-2303/code-runner/internal/data"
+	"github.com/VJ-2303/code-runner/internal/jobs"
+	"github.com/VJ-2303/code-runner/internal/runner"
+	"github.com/VJ-2303/code-runner/internal/validator"
+)
+
+// eventsPollTimeout is how long the events endpoint waits for new events
+// before sending a keep-alive comment.
+const eventsPollTimeout = 15 * time.Second
+
+// runInput is the request body shared by the synchronous and queued run endpoints.
+type runInput struct {
+	Code       string            `json:"code"`
+	Language   string            `json:"language"`
+	Version    string            `json:"language_version"`
+	Files      []runner.File     `json:"files"`
+	EntryPoint string            `json:"entry_point"`
+	Stdin      string            `json:"stdin"`
+	Args       []string          `json:"args"`
+	Env        map[string]string `json:"env"`
+	limitsInput
+}
+
+// limitsInput holds the run limits a request body asks for, zero for the
+// language's defaults.
+type limitsInput struct {
+	TimeLimitMS int64   `json:"time_limit_ms"`
+	MemoryMB    int     `json:"memory_mb"`
+	CPUs        float64 `json:"cpus"`
+}
+
+// limits converts in into run limits. A time limit too large or too small
+// for a time.Duration becomes the largest or smallest one instead of wrapping
+// around, so ValidateLimits rejects it like any other limit out of range.
+func (in limitsInput) limits() runner.Limits {
+	timeout := time.Duration(in.TimeLimitMS) * time.Millisecond
+	switch {
+	case in.TimeLimitMS > math.MaxInt64/int64(time.Millisecond):
+		timeout = math.MaxInt64
+	case in.TimeLimitMS < math.MinInt64/int64(time.Millisecond):
+		timeout = math.MinInt64
+	}
+
+	return runner.Limits{
+		Timeout:  runner.Duration(timeout),
+		MemoryMB: in.MemoryMB,
+		CPUs:     in.CPUs,
+	}
+}
+
+// readRunInput decodes and validates a run request body. It writes the error
+// response itself and returns false when the request should not go ahead.
+func (app *application) readRunInput(w http.ResponseWriter, r *http.Request) (runner.ExecuteRequest, bool) {
+	var input runInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return runner.ExecuteRequest{}, false
+	}
+
+	user := contextGetUser(r)
+
+	req := runner.ExecuteRequest{
+		UserID:     user.ID,
+		Code:       input.Code,
+		Language:   input.Language,
+		Version:    input.Version,
+		Files:      input.Files,
+		EntryPoint: app.entryPoint(input.Language, input.Files, input.EntryPoint),
+		Stdin:      []byte(input.Stdin),
+		Args:       input.Args,
+		Env:        input.Env,
+		Limits:     input.limits(),
+	}
+
+	v := validator.New()
+
+	data.ValidateLanguage(v, input.Language, app.languages.IDs())
+	runner.ValidateExecuteRequest(v, req)
+	if lang, ok := app.languages.Get(input.Language); ok {
+		runner.ValidateDependencies(v, lang, req.Files)
+		runner.ValidateVersion(v, lang, req.Version)
+
+		caps := lang.LimitCaps(app.config.tiers.Get(user.Tier))
+		runner.ValidateLimits(v, req.Limits, caps)
+		req.Limits = lang.EffectiveLimits(req.Limits, caps)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.FieldErrors)
+		return runner.ExecuteRequest{}, false
+	}
+	return req, true
+}
+
+// entryPoint defaults the entry point of a multi-file program to the file
+// name the language uses for single-file ones. Single-file programs have none.
+func (app *application) entryPoint(language string, files []runner.File, entryPoint string) string {
+	if len(files) == 0 {
+		return ""
+	}
+	if entryPoint != "" {
+		return entryPoint
+	}
+	if lang, ok := app.languages.Get(language); ok {
+		return lang.FileName
+	}
+	return ""
+}
+
+func (app *application) runCodeHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := app.readRunInput(w, r)
+	if !ok {
+		return
+	}
+
+	// Waiting for a slot, installing dependencies, compiling and running
+	// together can take longer than the server's write timeout allows.
+	lang, _ := app.languages.Get(req.Language)
+	err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(runDuration(lang, req)))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	result, err := app.runner.Run(r.Context(), req)
+	if err != nil {
+		if errors.Is(err, runner.ErrPoolFull) {
+			app.serverBusyResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if result.Status == runner.StatusInternalError {
+		app.logger.Error("sandbox failure", "language", req.Language, "error", result.Error)
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"result": result}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runDuration is an upper bound for running req in lang, leaving a minute
+// for waiting on an execution slot like judgeDuration.
+func runDuration(lang runner.Language, req runner.ExecuteRequest) time.Duration {
+	d := time.Minute + time.Duration(req.Limits.Timeout)
+	if lang.Dependencies != nil {
+		d += time.Duration(lang.Dependencies.InstallLimits.Timeout)
+	}
+	if lang.Compiled() {
+		d += time.Duration(lang.CompileLimits.Timeout)
+	}
+	return d
+}
+
+func (app *application) createRunJobHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := app.readRunInput(w, r)
+	if !ok {
+		return
+	}
+
+	user := contextGetUser(r)
+
+	job, err := app.jobs.Enqueue(r.Context(), user.ID, req)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/runs/%s", job.ID))
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"job": job}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getRunJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	job, err := app.jobs.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, jobs.ErrJobNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := contextGetUser(r)
+
+	if job.UserID != user.ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"job": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runEventsHandler relays a job's event stream as Server-Sent Events: the
+// output chunks of the run as they are produced, then a final "result"
+// event with the finished job. Reconnecting clients resume after the
+// Last-Event-ID they send.
+func (app *application) runEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	job, err := app.jobs.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, jobs.ErrJobNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := contextGetUser(r)
+
+	if job.UserID != user.ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = "0"
+	}
+
+	for {
+		// Each poll pushes the write deadline out again, so the stream can
+		// outlive the server's write timeout.
+		err := rc.SetWriteDeadline(time.Now().Add(2 * eventsPollTimeout))
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+
+		events, err := app.jobs.Events(r.Context(), id, last, eventsPollTimeout)
+		if err != nil {
+			if r.Context().Err() == nil {
+				app.logError(r, err)
+			}
+			return
+		}
+
+		if len(events) == 0 {
+			// A job whose events have expired or were never recorded
+			// still gets its final event.
+			job, err := app.jobs.Get(r.Context(), id)
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+			if job.Status == jobs.StatusDone || job.Status == jobs.StatusFailed {
+				js, err := json.Marshal(job)
+				if err != nil {
+					app.logError(r, err)
+					return
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", jobs.EventResult, js)
+				rc.Flush()
+				return
+			}
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+
+		for _, e := range events {
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Name, e.Data)
+			last = e.ID
+			if e.Name == jobs.EventResult {
+				rc.Flush()
+				return
+			}
+		}
+
+		err = rc.Flush()
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/VJ-2303/code-runner/internal/data"
+	"github.com/VJ-2303/code-runner/internal/runner"
+	"github.com/VJ-2303/code-runner/internal/validator"
+)
+
+func (app *application) createSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Language string `json:"language"`
+		Version  string `json:"language_version"`
+		// The timeout bounds each cell.
+		limitsInput
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := contextGetUser(r)
+
+	v := validator.New()
+
+	req := runner.ExecuteRequest{
+		UserID:   user.ID,
+		Language: input.Language,
+		Version:  input.Version,
+		Limits:   input.limits(),
+	}
+
+	data.ValidateLanguage(v, input.Language, app.languages.IDs())
+	if lang, ok := app.languages.Get(input.Language); ok {
+		v.Check(lang.REPL != nil, "language", "does not support sessions")
+		runner.ValidateVersion(v, lang, req.Version)
+		if req.Version == "" {
+			req.Version = lang.Version
+		}
+
+		caps := lang.LimitCaps(app.config.tiers.Get(user.Tier))
+		runner.ValidateLimits(v, req.Limits, caps)
+		req.Limits = lang.EffectiveLimits(req.Limits, caps)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.FieldErrors)
+		return
+	}
+
+	session, err := app.repls.Create(r.Context(), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, runner.ErrREPLFull), errors.Is(err, runner.ErrPoolFull):
+			app.serverBusyResponse(w, r)
+		case errors.Is(err, runner.ErrREPLLimit):
+			app.tooManySessionsResponse(w, r)
+		case errors.Is(err, runner.ErrInteractiveUnsupported):
+			app.errorResponse(w, r, http.StatusNotImplemented, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/sessions/%s", session.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"session": session}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) evalSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Code != "", "code", "must be provided")
+	v.Check(len(input.Code) <= runner.MaxFileBytes, "code", "must not be more than 256KB")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.FieldErrors)
+		return
+	}
+
+	user := contextGetUser(r)
+
+	result, err := app.repls.Eval(user.ID, r.PathValue("id"), input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, runner.ErrREPLNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, runner.ErrREPLBusy):
+			app.sessionBusyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if result.Status == runner.StatusInternalError {
+		app.logger.Error("sandbox failure", "session", r.PathValue("id"), "error", result.Error)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"result": result}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user := contextGetUser(r)
+
+	err := app.repls.Delete(user.ID, r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, runner.ErrREPLNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusGone, envelope{"message": "Session deleted successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/VJ-2303/code-runner/internal/data"
+	"github.com/VJ-2303/code-runner/internal/runner"
+	"github.com/VJ-2303/code-runner/internal/validator"
+	"github.com/gorilla/websocket"
+)
+
+// Any origin may connect, just like CORS allows, as requests are
+// authenticated with a bearer token rather than cookies.
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// terminalMessage is what the client sends: a "start" message first, then
+// "input", "resize" and "terminate" messages.
+type terminalMessage struct {
+	Type       string            `json:"type"`
+	Code       string            `json:"code"`
+	Language   string            `json:"language"`
+	Version    string            `json:"language_version"`
+	Files      []runner.File     `json:"files"`
+	EntryPoint string            `json:"entry_point"`
+	Args       []string          `json:"args"`
+	Env        map[string]string `json:"env"`
+	MemoryMB   int               `json:"memory_mb"`
+	CPUs       float64           `json:"cpus"`
+	Data       string            `json:"data"`
+	Rows       uint16            `json:"rows"`
+	Cols       uint16            `json:"cols"`
+}
+
+// terminalHandler runs a program attached to a pseudo-terminal over a
+// WebSocket. The terminal's output goes out as binary messages, followed by
+// an "exit" message with the result once the program has ended.
+func (app *application) terminalHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client.
+		return
+	}
+	defer conn.Close()
+
+	// The start message carries files, which may be a lot bigger than
+	// anything typed later.
+	conn.SetReadLimit(1_048_576)
+	start, err := readTerminalMessage(conn)
+	if err != nil || start.Type != "start" {
+		writeTerminalError(conn, "the first message must be a valid start message")
+		return
+	}
+	conn.SetReadLimit(runner.MaxStdinBytes)
+
+	user := contextGetUser(r)
+
+	req := runner.ExecuteRequest{
+		UserID:     user.ID,
+		Code:       start.Code,
+		Language:   start.Language,
+		Version:    start.Version,
+		Files:      start.Files,
+		EntryPoint: app.entryPoint(start.Language, start.Files, start.EntryPoint),
+		Args:       start.Args,
+		Env:        start.Env,
+		// The session limits take the place of a time limit.
+		Limits: runner.Limits{MemoryMB: start.MemoryMB, CPUs: start.CPUs},
+	}
+
+	v := validator.New()
+
+	data.ValidateLanguage(v, start.Language, app.languages.IDs())
+	runner.ValidateExecuteRequest(v, req)
+	if lang, ok := app.languages.Get(start.Language); ok {
+		runner.ValidateDependencies(v, lang, req.Files)
+		runner.ValidateVersion(v, lang, req.Version)
+
+		caps := lang.LimitCaps(app.config.tiers.Get(user.Tier))
+		runner.ValidateLimits(v, req.Limits, caps)
+		req.Limits = lang.EffectiveLimits(req.Limits, caps)
+	}
+
+	if !v.Valid() {
+		writeTerminalError(conn, v.FieldErrors)
+		return
+	}
+
+	ir, ok := app.runner.(runner.InteractiveRunner)
+	if !ok {
+		writeTerminalError(conn, runner.ErrInteractiveUnsupported.Error())
+		return
+	}
+
+	limits := runner.SessionLimits{Idle: app.config.session.idle, Total: app.config.session.total}
+	sess, err := ir.Start(r.Context(), req, limits, runner.TerminalSize{Rows: start.Rows, Cols: start.Cols})
+	if err != nil {
+		switch {
+		case errors.Is(err, runner.ErrPoolFull):
+			writeTerminalError(conn, "the server is busy, please try again later")
+		case errors.Is(err, runner.ErrInteractiveUnsupported):
+			writeTerminalError(conn, err.Error())
+		default:
+			app.logError(r, err)
+			writeTerminalError(conn, "the server encountered a problem and could not process your request")
+		}
+		return
+	}
+	defer sess.Close()
+
+	// This goroutine is the only writer to conn from here on.
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+
+		buf := make([]byte, 4096)
+		for {
+			n, err := sess.Read(buf)
+			if n > 0 {
+				if conn.WriteMessage(websocket.BinaryMessage, buf[:n]) != nil {
+					sess.Terminate()
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+
+		result, err := sess.Wait()
+		if err != nil {
+			app.logError(r, err)
+			writeTerminalError(conn, "the server encountered a problem and could not process your request")
+			return
+		}
+		if result.Status == runner.StatusInternalError {
+			app.logger.Error("sandbox failure", "language", req.Language, "error", result.Error)
+		}
+		conn.WriteJSON(envelope{"type": "exit", "result": result})
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	}()
+
+	// Closing conn on return ends this reader, if the client did not
+	// close the connection after the exit message itself.
+	go func() {
+		for {
+			msg, err := readTerminalMessage(conn)
+			if err != nil {
+				sess.Terminate()
+				return
+			}
+			switch msg.Type {
+			case "input":
+				sess.Write([]byte(msg.Data))
+			case "resize":
+				sess.Resize(runner.TerminalSize{Rows: msg.Rows, Cols: msg.Cols})
+			case "terminate":
+				sess.Terminate()
+			}
+		}
+	}()
+
+	<-outputDone
+}
+
+func readTerminalMessage(conn *websocket.Conn) (*terminalMessage, error) {
+	_, b, err := conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+
+	var msg terminalMessage
+	err = dec.Decode(&msg)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func writeTerminalError(conn *websocket.Conn, message any) {
+	conn.WriteJSON(envelope{"type": "error", "error": message})
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, ""), time.Now().Add(time.Second))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/VJ-2303/code-runner/internal/data"
+	"github.com/VJ-2303/code-runner/internal/runner"
+	"github.com/VJ-2303/code-runner/internal/validator"
+)
+
+func (app *application) runTestsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code       string            `json:"code"`
+		Language   string            `json:"language"`
+		Version    string            `json:"language_version"`
+		Files      []runner.File     `json:"files"`
+		EntryPoint string            `json:"entry_point"`
+		Test       string            `json:"test"`
+		Env        map[string]string `json:"env"`
+		limitsInput
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := contextGetUser(r)
+
+	req := runner.ExecuteRequest{
+		UserID:     user.ID,
+		Code:       input.Code,
+		Language:   input.Language,
+		Version:    input.Version,
+		Files:      input.Files,
+		EntryPoint: app.entryPoint(input.Language, input.Files, input.EntryPoint),
+		Env:        input.Env,
+		TestCode:   input.Test,
+		Limits:     input.limits(),
+	}
+
+	v := validator.New()
+
+	data.ValidateLanguage(v, input.Language, app.languages.IDs())
+	runner.ValidateExecuteRequest(v, req)
+	v.Check(input.Test != "", "test", "must be provided")
+	lang, ok := app.languages.Get(input.Language)
+	if ok {
+		runner.ValidateDependencies(v, lang, req.Files)
+		runner.ValidateVersion(v, lang, req.Version)
+		v.Check(lang.Test != nil, "language", "has no test framework")
+		for _, f := range req.Files {
+			v.Check(lang.Test == nil || f.Path != lang.Test.FileName, "files", "must not contain the test file "+f.Path)
+		}
+
+		if lang.Test != nil {
+			caps := lang.LimitCaps(app.config.tiers.Get(user.Tier))
+			runner.ValidateLimits(v, req.Limits, caps)
+			req.Limits = lang.EffectiveTestLimits(req.Limits, caps)
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.FieldErrors)
+		return
+	}
+
+	// Test runs may take longer than the server's write timeout, more so
+	// when their dependencies have to be installed first.
+	d := time.Minute + time.Duration(req.Limits.Timeout)
+	if lang.Dependencies != nil {
+		d += time.Duration(lang.Dependencies.InstallLimits.Timeout)
+	}
+	err = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	result, err := app.tester.Run(r.Context(), lang, req)
+	if err != nil {
+		if errors.Is(err, runner.ErrPoolFull) {
+			app.serverBusyResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if result.Run.Status == runner.StatusInternalError {
+		app.logger.Error("sandbox failure", "language", req.Language, "error", result.Run.Error)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"test": result}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,140 @@
+// Command pin-images pins the images of a languages file by the digests the
+// registry serves for their tags, which the API and the worker require so
+// that a tag moving on cannot silently switch a toolchain. It needs a Docker
+// CLI with buildx and access to the registry. Test images that the registry
+// does not have, such as those of the images directory built on this host,
+// are pinned by their local image ID instead. Run it again with -update to
+// move the pinned images on to the current builds of their tags; image IDs
+// stay as they are until they are replaced by a tag again.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+var (
+	// imageRX matches the image fields of a languages file, leaving the
+	// rest of it as it was written.
+	imageRX   = regexp.MustCompile(`("(image|test_image)":\s*")([^"]+)(")`)
+	imageIDRX = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+)
+
+func main() {
+	var (
+		file   string
+		docker string
+		update bool
+	)
+
+	flag.StringVar(&file, "file", "languages.json", "Path to the languages file to pin")
+	flag.StringVar(&docker, "docker", "docker", "Docker CLI binary")
+	flag.BoolVar(&update, "update", false, "Resolve images that are already pinned again")
+
+	flag.Parse()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	err := pin(logger, file, docker, update)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+func pin(logger *slog.Logger, file, docker string, update bool) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	// Versions of a language may share an image, which is resolved once.
+	pins := make(map[string]string)
+	var failed error
+
+	pinned := imageRX.ReplaceAllFunc(content, func(m []byte) []byte {
+		parts := imageRX.FindSubmatch(m)
+		field, image := string(parts[2]), string(parts[3])
+		if failed != nil || imageIDRX.MatchString(image) {
+			return m
+		}
+		tag, _, found := strings.Cut(image, "@")
+		if found && !update {
+			return m
+		}
+
+		if _, ok := pins[tag]; !ok {
+			pin, err := resolve(docker, tag)
+			if err != nil && field == "test_image" {
+				// Test images may be built on the host and never pushed.
+				pin, err = localID(docker, tag)
+			}
+			if err != nil {
+				failed = err
+				return m
+			}
+			pins[tag] = pin
+		}
+		if pins[tag] != image {
+			logger.Info("image pinned", "image", tag, "pinned", pins[tag])
+		}
+		return fmt.Appendf(nil, "%s%s%s", parts[1], pins[tag], parts[4])
+	})
+	if failed != nil {
+		return failed
+	}
+
+	if bytes.Equal(pinned, content) {
+		logger.Info("all images are pinned already", "file", file)
+		return nil
+	}
+	return os.WriteFile(file, pinned, info.Mode().Perm())
+}
+
+// resolve returns image pinned by the digest the registry serves for it, that
+// of the index for multi-platform images.
+func resolve(docker, image string) (string, error) {
+	out, err := exec.Command(docker, "buildx", "imagetools", "inspect", "--format", "{{json .Manifest}}", image).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("failed to resolve %s: %s", image, bytes.TrimSpace(exitErr.Stderr))
+		}
+		return "", fmt.Errorf("failed to resolve %s: %w", image, err)
+	}
+
+	var manifest struct {
+		Digest string `json:"digest"`
+	}
+	err = json.Unmarshal(out, &manifest)
+	if err != nil || !imageIDRX.MatchString(manifest.Digest) {
+		return "", fmt.Errorf("failed to resolve %s: unexpected output %q", image, out)
+	}
+	return image + "@" + manifest.Digest, nil
+}
+
+// localID returns the ID of image as built on this host. Runs on other hosts
+// need the same build, so their IDs have to match.
+func localID(docker, image string) (string, error) {
+	out, err := exec.Command(docker, "image", "inspect", "--format", "{{.Id}}", image).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s in the registry or locally", image)
+	}
+	id := string(bytes.TrimSpace(out))
+	if !imageIDRX.MatchString(id) {
+		return "", fmt.Errorf("failed to resolve %s: unexpected image ID %q", image, id)
+	}
+	return id, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/VJ-2303/code-runner/internal/jobs"
+	"github.com/VJ-2303/code-runner/internal/runner"
+	"github.com/VJ-2303/code-runner/internal/sandbox"
+	"github.com/joho/godotenv"
+)
+
+type config struct {
+	redis struct {
+		addr     string
+		password string
+	}
+	sandbox     sandbox.Config
+	concurrency int
+	weights     runner.Weights
+}
+
+func main() {
+	var cfg config
+
+	godotenv.Load()
+
+	flag.StringVar(&cfg.redis.addr, "redis-addr", "localhost:6379", "Redis Address")
+	flag.StringVar(&cfg.redis.password, "redis-pass", "pa55word", "Redis password")
+
+	cfg.sandbox.RegisterFlags()
+
+	flag.IntVar(&cfg.concurrency, "concurrency", 4, "Number of run jobs executed concurrently")
+	flag.Func("runner-weights", "Scheduling weights for trusted users as userID:weight,... (default weight 1)", func(s string) error {
+		weights, err := runner.ParseWeights(s)
+		if err != nil {
+			return err
+		}
+		cfg.weights = weights
+		return nil
+	})
+
+	flag.Parse()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	rdb, err := jobs.OpenRedis(cfg.redis.addr, cfg.redis.password)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer rdb.Close()
+
+	logger.Info("redis database connection established")
+
+	languages, err := cfg.sandbox.OpenLanguages()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	sb, err := cfg.sandbox.Open(languages)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	sandbox.Reap(logger, sb)
+
+	worker := &jobs.Worker{
+		Queue:       jobs.NewQueue(rdb, cfg.weights),
+		Runner:      sb,
+		Logger:      logger,
+		Concurrency: cfg.concurrency,
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		logger.Info("starting worker", "concurrency", cfg.concurrency)
+		worker.Serve(ctx)
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	for s := range sig {
+		if s == syscall.SIGHUP {
+			cfg.sandbox.ReloadLanguages(logger, languages)
+			continue
+		}
+
+		logger.Info("shutting down worker, waiting for running jobs", "signal", s.String())
+		stop()
+		<-done
+		break
+	}
+	sb.Close()
+	logger.Info("worker stopped")
+}
@@ -4,16 +4,20 @@ go 1.25.1
 
 require github.com/lib/pq v1.10.9
 
-require golang.org/x/crypto v0.47.0
+require (
+	github.com/creack/pty v1.1.24
+	github.com/gorilla/websocket v1.5.3
+	github.com/redis/go-redis/v9 v9.17.3
+	golang.org/x/crypto v0.47.0
+)
 
 require (
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/redis/go-redis/v9 v9.17.3 // indirect
 )
 
 require (
-	github.com/go-mail/mail/v2 v2.3.0 // indirect
+	github.com/go-mail/mail/v2 v2.3.0
 	github.com/joho/godotenv v1.5.1
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 )
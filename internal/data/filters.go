@@ -8,9 +8,9 @@ type Filters struct {
 	Page     int
 }
 
-func ValidateFilters(v *validator.Validator, f Filters) {
+func ValidateFilters(v *validator.Validator, f Filters, languages []string) {
 	if f.Language != "" {
-		v.Check(validator.PermittedValue(f.Language, "ruby", "python", "javascript"), "language", "must be either ruby, python or javascript")
+		ValidateLanguage(v, f.Language, languages)
 	}
 	v.Check(f.Page >= 0, "page", "page must be greater than 0")
 	v.Check(f.PageSize >= 3, "page_size", "page size must be greater than 2")
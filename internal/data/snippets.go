@@ -3,9 +3,12 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"strings"
 	"time"
 
+	"github.com/VJ-2303/code-runner/internal/runner"
 	"github.com/VJ-2303/code-runner/internal/validator"
 )
 
@@ -19,15 +22,32 @@ type Snippet struct {
 	ExpiresAt time.Time `json:"expires_at"`
 	Version   int32     `json:"version"`
 
+	// Files holds multi-file snippets, in which case Content is empty.
+	Files      []runner.File `json:"files,omitempty"`
+	EntryPoint string        `json:"entry_point,omitempty"`
+	// LanguageVersion pins the snippet to one version of its language.
+	// Empty means whichever version is the default.
+	LanguageVersion string `json:"language_version,omitempty"`
+
 	ShareToken *string `json:"share_token,omitempty"`
 }
 
-func ValidateSnippet(v *validator.Validator, snippet *Snippet) {
+func ValidateSnippet(v *validator.Validator, snippet *Snippet, languages []string) {
 	v.Check(snippet.Title != "", "title", "must be provided")
 	v.Check(len(snippet.Title) <= 100, "title", "must not be more than 100 bytes")
 
-	v.Check(snippet.Content != "", "content", "must be provided")
-	v.Check(validator.PermittedValue(snippet.Language, "ruby", "python", "javascript"), "language", "must be either ruby, python, or javascript")
+	if len(snippet.Files) == 0 {
+		v.Check(snippet.Content != "", "content", "must be provided")
+	} else {
+		v.Check(snippet.Content == "", "content", "must not be provided together with files")
+		runner.ValidateFiles(v, snippet.Files, snippet.EntryPoint)
+	}
+	ValidateLanguage(v, snippet.Language, languages)
+}
+
+// ValidateLanguage checks language against the IDs of the runner's language registry.
+func ValidateLanguage(v *validator.Validator, language string, languages []string) {
+	v.Check(validator.PermittedValue(language, languages...), "language", "must be one of "+strings.Join(languages, ", "))
 }
 
 type SnippetMini struct {
@@ -43,15 +63,23 @@ type SnippetModel struct {
 
 func (m SnippetModel) Insert(snippet *Snippet) error {
 	query := `
-			INSERT INTO snippets (user_id,title, content, language, expires_at)
-			VALUES ($1, $2, $3, $4, $5)
+			INSERT INTO snippets (user_id,title, content, language, language_version, files, entry_point, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 			RETURNING id, created_at, version`
 
+	files, err := marshalFiles(snippet.Files)
+	if err != nil {
+		return err
+	}
+
 	args := []any{
 		snippet.UserID,
 		snippet.Title,
 		snippet.Content,
 		snippet.Language,
+		snippet.LanguageVersion,
+		files,
+		snippet.EntryPoint,
 		snippet.ExpiresAt,
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -66,11 +94,12 @@ func (m SnippetModel) Get(id int64) (*Snippet, error) {
 	}
 
 	query := `
-		SELECT id,user_id, title, content, language, created_at, expires_at, version
+		SELECT id,user_id, title, content, language, language_version, files, entry_point, created_at, expires_at, version
 		FROM snippets
 		WHERE id = $1`
 
 	var snippet Snippet
+	var files []byte
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -81,6 +110,9 @@ func (m SnippetModel) Get(id int64) (*Snippet, error) {
 		&snippet.Title,
 		&snippet.Content,
 		&snippet.Language,
+		&snippet.LanguageVersion,
+		&files,
+		&snippet.EntryPoint,
 		&snippet.CreatedAt,
 		&snippet.ExpiresAt,
 		&snippet.Version,
@@ -94,6 +126,10 @@ func (m SnippetModel) Get(id int64) (*Snippet, error) {
 		return nil, err
 	}
 
+	snippet.Files, err = unmarshalFiles(files)
+	if err != nil {
+		return nil, err
+	}
 	return &snippet, nil
 }
 
@@ -140,16 +176,21 @@ func (m SnippetModel) GetAllForUserID(userID int64, f Filters) ([]*SnippetMini,
 
 func (m SnippetModel) Update(snippet *Snippet) error {
 	query := `
-			UPDATE snippets SET title = $1, content = $2, language = $3, version = version + 1
-			WHERE id = $4 and version = $5
+			UPDATE snippets SET title = $1, content = $2, language = $3, language_version = $4, files = $5, entry_point = $6, version = version + 1
+			WHERE id = $7 and version = $8
 			RETURNING version
 	`
-	args := []any{snippet.Title, snippet.Content, snippet.Language, snippet.ID, snippet.Version}
+	files, err := marshalFiles(snippet.Files)
+	if err != nil {
+		return err
+	}
+
+	args := []any{snippet.Title, snippet.Content, snippet.Language, snippet.LanguageVersion, files, snippet.EntryPoint, snippet.ID, snippet.Version}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&snippet.Version)
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&snippet.Version)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return ErrEditConflict
@@ -196,7 +237,7 @@ func (m SnippetModel) SetShareToken(id int64, userID int64, token string) error
 
 func (m SnippetModel) GetByShareToken(token string) (*Snippet, error) {
 	query := `
-		SELECT id, user_id, title, content, language, created_at, expires_at, version, share_token
+		SELECT id, user_id, title, content, language, language_version, files, entry_point, created_at, expires_at, version, share_token
 		FROM snippets
 		WHERE share_token = $1
 			 `
@@ -204,6 +245,7 @@ func (m SnippetModel) GetByShareToken(token string) (*Snippet, error) {
 	defer cancel()
 
 	var s Snippet
+	var files []byte
 
 	err := m.DB.QueryRowContext(ctx, query, token).Scan(
 		&s.ID,
@@ -211,6 +253,9 @@ func (m SnippetModel) GetByShareToken(token string) (*Snippet, error) {
 		&s.Title,
 		&s.Content,
 		&s.Language,
+		&s.LanguageVersion,
+		&files,
+		&s.EntryPoint,
 		&s.CreatedAt,
 		&s.ExpiresAt,
 		&s.Version,
@@ -223,5 +268,30 @@ func (m SnippetModel) GetByShareToken(token string) (*Snippet, error) {
 		}
 		return nil, err
 	}
+
+	s.Files, err = unmarshalFiles(files)
+	if err != nil {
+		return nil, err
+	}
 	return &s, nil
 }
+
+// marshalFiles encodes files for the jsonb files column, which is never null.
+func marshalFiles(files []runner.File) ([]byte, error) {
+	if files == nil {
+		files = []runner.File{}
+	}
+	return json.Marshal(files)
+}
+
+func unmarshalFiles(b []byte) ([]runner.File, error) {
+	var files []runner.File
+	err := json.Unmarshal(b, &files)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	return files, nil
+}
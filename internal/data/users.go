@@ -21,7 +21,10 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
-	Version   int       `json:"version"`
+	// Tier selects the run limits the user may ask for. It is only ever
+	// changed by operators, directly in the database.
+	Tier    string `json:"tier"`
+	Version int    `json:"version"`
 }
 
 type UserStats struct {
@@ -96,14 +99,14 @@ func (m UserModel) Insert(user *User) error {
 	query := `
 		INSERT INTO users (name, email, password_hash, activated)
 		VALUES ($1, $2, $3, $4)
-		RETURNING id, created_at, version`
+		RETURNING id, created_at, tier, version`
 
 	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Tier, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -118,7 +121,7 @@ func (m UserModel) Insert(user *User) error {
 // GetByEmail retrieves a user details by their email address.
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password_hash, activated, version
+		SELECT id, created_at, name, email, password_hash, activated, tier, version
 		FROM users
 		WHERE email = $1`
 
@@ -134,6 +137,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Tier,
 		&user.Version,
 	)
 
@@ -151,7 +155,7 @@ func (m UserModel) GetForToken(scope string, tokenPlainText string) (*User, erro
 	hashToken := sha256.Sum256([]byte(tokenPlainText))
 
 	query := `
-		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.tier, users.version
 		FROM users
 		INNER JOIN tokens
 		ON users.id = tokens.user_id
@@ -174,6 +178,7 @@ func (m UserModel) GetForToken(scope string, tokenPlainText string) (*User, erro
 		&u.Email,
 		&u.Password.hash,
 		&u.Activated,
+		&u.Tier,
 		&u.Version,
 	)
 	if err != nil {
@@ -0,0 +1,389 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VJ-2303/code-runner/internal/runner"
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+// Queued jobs live in one list per user. The ring list holds the IDs of
+// users that have jobs waiting, and workers serve it in weighted round-robin
+// order so a user with hundreds of jobs cannot hold up everybody else.
+//
+// Every enqueued job also pushes to the wake list, which idle workers block
+// on. The wake-up only says that there may be work: the job itself is taken
+// in one script, so a worker dying in between loses nothing but the wake-up,
+// and workers poll the ring as well.
+//
+// Jobs being run sit in the running set, scored by when their lease runs
+// out. Workers renew the leases of their jobs, so the jobs of a worker that
+// died are put back at the front of their user's list once their leases
+// expire, and failed after maxAttempts.
+const (
+	ringKey         = "run_jobs:users"
+	wakeKey         = "run_jobs:wake"
+	runningKey      = "run_jobs:running"
+	attemptsKey     = "run_jobs:attempts"
+	userQueuePrefix = "run_jobs:user:"
+	turnsPrefix     = "run_jobs:turns:"
+	jobPrefix       = "run_job:"
+	eventsSuffix    = ":events"
+	jobTTL          = 24 * time.Hour
+
+	leaseTimeout = time.Minute
+	maxAttempts  = 3
+
+	// maxEvents caps the output chunks kept per job. Older ones are
+	// trimmed away, the final result still holds the whole output.
+	maxEvents = 10000
+)
+
+// enqueueScript stores the job and appends it to the user's list. The user
+// joins the ring only when their list was empty, otherwise they are already
+// in it.
+var enqueueScript = redis.NewScript(`
+		redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[2])
+		redis.call('RPUSH', KEYS[2], ARGV[3])
+		if redis.call('LLEN', KEYS[2]) == 1 then
+			redis.call('RPUSH', KEYS[3], ARGV[4])
+		end
+		redis.call('RPUSH', KEYS[4], 1)
+		return 1
+	`)
+
+// dequeueScript takes the user at the front of the ring and the next of
+// their jobs, which it moves to the running set as "userID:jobID". If the
+// user has more jobs they go back to the front of the ring until they have
+// used up their weight, and to the back after that. ARGV[5:] are the
+// weights of users that have one, as userID, weight pairs.
+var dequeueScript = redis.NewScript(`
+		local user = redis.call('LPOP', KEYS[1])
+		if not user then
+			return false
+		end
+		local queue = ARGV[3] .. user
+		local turnsKey = ARGV[4] .. user
+		local id = redis.call('LPOP', queue)
+		if not id then
+			return false
+		end
+		if redis.call('LLEN', queue) == 0 then
+			redis.call('DEL', turnsKey)
+		else
+			local weight = 1
+			for i = 5, #ARGV, 2 do
+				if ARGV[i] == user then
+					weight = tonumber(ARGV[i + 1])
+				end
+			end
+			local turns = redis.call('INCR', turnsKey)
+			redis.call('EXPIRE', turnsKey, ARGV[2])
+			if turns < weight then
+				redis.call('LPUSH', KEYS[1], user)
+			else
+				redis.call('DEL', turnsKey)
+				redis.call('RPUSH', KEYS[1], user)
+			end
+		end
+		local member = user .. ':' .. id
+		local now = tonumber(redis.call('TIME')[1])
+		redis.call('ZADD', KEYS[2], now + tonumber(ARGV[1]), member)
+		redis.call('HINCRBY', KEYS[3], id, 1)
+		return member
+	`)
+
+// renewScript extends the lease of a running job, unless it was already
+// taken back.
+var renewScript = redis.NewScript(`
+		local now = tonumber(redis.call('TIME')[1])
+		return redis.call('ZADD', KEYS[1], 'XX', 'CH', now + tonumber(ARGV[1]), ARGV[2])
+	`)
+
+// recoverScript takes the running jobs whose leases ran out back to the
+// front of their users' lists. It returns the jobs that ran out of attempts
+// instead, as "userID:jobID", which stay in the running set until the caller
+// has failed them.
+var recoverScript = redis.NewScript(`
+		local now = tonumber(redis.call('TIME')[1])
+		local expired = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', now, 'LIMIT', 0, 100)
+		local failed = {}
+		for _, member in ipairs(expired) do
+			local user, id = string.match(member, '^([^:]+):(.+)$')
+			local attempts = tonumber(redis.call('HGET', KEYS[2], id) or '0')
+			if redis.call('EXISTS', ARGV[2] .. id) == 0 then
+				redis.call('ZREM', KEYS[1], member)
+				redis.call('HDEL', KEYS[2], id)
+			elseif attempts >= tonumber(ARGV[1]) then
+				table.insert(failed, member)
+			else
+				redis.call('ZREM', KEYS[1], member)
+				local queue = ARGV[3] .. user
+				redis.call('LPUSH', queue, id)
+				if redis.call('LLEN', queue) == 1 then
+					redis.call('RPUSH', KEYS[3], user)
+				end
+				redis.call('RPUSH', KEYS[4], 1)
+			end
+		end
+		return failed
+	`)
+
+type Status string
+
+const (
+	StatusQueued  Status = "queued"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+type Job struct {
+	ID         string                `json:"id"`
+	UserID     int64                 `json:"-"`
+	Language   string                `json:"language"`
+	Status     Status                `json:"status"`
+	Result     *runner.ExecuteResult `json:"result,omitempty"`
+	Error      string                `json:"error,omitempty"`
+	CreatedAt  time.Time             `json:"created_at"`
+	StartedAt  *time.Time            `json:"started_at,omitempty"`
+	FinishedAt *time.Time            `json:"finished_at,omitempty"`
+}
+
+// record is what gets stored in Redis. The request is kept next to the job
+// so the worker can execute it, but it is never sent back to clients.
+type record struct {
+	Job     Job                   `json:"job"`
+	UserID  int64                 `json:"user_id"`
+	Request runner.ExecuteRequest `json:"request"`
+}
+
+type Queue struct {
+	Redis   *redis.Client
+	Weights runner.Weights
+}
+
+func NewQueue(rdb *redis.Client, weights runner.Weights) *Queue {
+	return &Queue{Redis: rdb, Weights: weights}
+}
+
+func (q *Queue) Enqueue(ctx context.Context, userID int64, req runner.ExecuteRequest) (*Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	rec := record{
+		Job: Job{
+			ID:        id,
+			UserID:    userID,
+			Language:  req.Language,
+			Status:    StatusQueued,
+			CreatedAt: time.Now(),
+		},
+		UserID:  userID,
+		Request: req,
+	}
+
+	js, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{jobPrefix + id, userQueueKey(userID), ringKey, wakeKey}
+	err = enqueueScript.Run(ctx, q.Redis, keys, js, int(jobTTL.Seconds()), id, userID).Err()
+	if err != nil {
+		return nil, err
+	}
+	return &rec.Job, nil
+}
+
+func (q *Queue) Get(ctx context.Context, id string) (*Job, error) {
+	rec, err := q.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &rec.Job, nil
+}
+
+// dequeue blocks for up to timeout waiting for the next job. It returns
+// redis.Nil when nothing arrived in time.
+func (q *Queue) dequeue(ctx context.Context, timeout time.Duration) (*record, error) {
+	err := q.Redis.BLPop(ctx, timeout, wakeKey).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	args := []any{int(leaseTimeout.Seconds()), int(jobTTL.Seconds()), userQueuePrefix, turnsPrefix}
+	for userID, weight := range q.Weights {
+		args = append(args, userID, weight)
+	}
+	member, err := dequeueScript.Run(ctx, q.Redis, []string{ringKey, runningKey, attemptsKey}, args...).Text()
+	if err != nil {
+		return nil, err
+	}
+
+	_, id, _ := strings.Cut(member, ":")
+	rec, err := q.load(ctx, id)
+	if errors.Is(err, ErrJobNotFound) {
+		q.Redis.ZRem(ctx, runningKey, member)
+		q.Redis.HDel(ctx, attemptsKey, id)
+	}
+	return rec, err
+}
+
+// renew extends the lease of a running job.
+func (q *Queue) renew(ctx context.Context, rec *record) error {
+	return renewScript.Run(ctx, q.Redis, []string{runningKey}, int(leaseTimeout.Seconds()), runningMember(rec)).Err()
+}
+
+// complete stores the finished job and releases its lease in one go, so a
+// job is either still running or finished for good.
+func (q *Queue) complete(ctx context.Context, rec *record) error {
+	js, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = q.Redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, jobPrefix+rec.Job.ID, js, jobTTL)
+		pipe.ZRem(ctx, runningKey, runningMember(rec))
+		pipe.HDel(ctx, attemptsKey, rec.Job.ID)
+		return nil
+	})
+	return err
+}
+
+// recover puts the jobs of workers that stopped renewing their leases back
+// into the queue, and returns those that already ran out of attempts.
+func (q *Queue) recover(ctx context.Context) ([]*record, error) {
+	keys := []string{runningKey, attemptsKey, ringKey, wakeKey}
+	members, err := recoverScript.Run(ctx, q.Redis, keys, maxAttempts, jobPrefix, userQueuePrefix).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	var failed []*record
+	for _, member := range members {
+		_, id, _ := strings.Cut(member, ":")
+		rec, err := q.load(ctx, id)
+		if err != nil {
+			return failed, err
+		}
+		failed = append(failed, rec)
+	}
+	return failed, nil
+}
+
+func runningMember(rec *record) string {
+	return strconv.FormatInt(rec.UserID, 10) + ":" + rec.Job.ID
+}
+
+func userQueueKey(userID int64) string {
+	return userQueuePrefix + strconv.FormatInt(userID, 10)
+}
+
+func (q *Queue) load(ctx context.Context, id string) (*record, error) {
+	js, err := q.Redis.Get(ctx, jobPrefix+id).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+
+	var rec record
+	err = json.Unmarshal(js, &rec)
+	if err != nil {
+		return nil, err
+	}
+	rec.Job.UserID = rec.UserID
+	return &rec, nil
+}
+
+func (q *Queue) save(ctx context.Context, rec *record) error {
+	js, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return q.Redis.Set(ctx, jobPrefix+rec.Job.ID, js, jobTTL).Err()
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Event is an entry of a job's event stream: an output chunk named after
+// its stream, or the final EventResult. Data is JSON.
+type Event struct {
+	ID   string
+	Name string
+	Data string
+}
+
+// EventResult is the last event of every job and carries the finished job.
+const EventResult = "result"
+
+func eventsKey(id string) string {
+	return jobPrefix + id + eventsSuffix
+}
+
+func (q *Queue) publish(ctx context.Context, id, name string, payload any) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	key := eventsKey(id)
+	_, err = q.Redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: key,
+			MaxLen: maxEvents,
+			Approx: true,
+			Values: []any{"name", name, "data", js},
+		})
+		pipe.Expire(ctx, key, jobTTL)
+		return nil
+	})
+	return err
+}
+
+// Events returns the events of a job that came after the one with ID after,
+// "0" for all of them. It waits up to block for new ones and returns none
+// if nothing arrived in time.
+func (q *Queue) Events(ctx context.Context, id, after string, block time.Duration) ([]Event, error) {
+	streams, err := q.Redis.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{eventsKey(id), after},
+		Count:   100,
+		Block:   block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			name, _ := msg.Values["name"].(string)
+			data, _ := msg.Values["data"].(string)
+			events = append(events, Event{ID: msg.ID, Name: name, Data: data})
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,27 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// OpenRedis connects to the Redis server holding the queue and checks that
+// it answers.
+func OpenRedis(addr, password string) (*redis.Client, error) {
+	rdb := redis.NewClient(
+		&redis.Options{
+			Addr:     addr,
+			Password: password,
+		},
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := rdb.Ping(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	return rdb, nil
+}
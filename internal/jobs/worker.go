@@ -0,0 +1,175 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/VJ-2303/code-runner/internal/runner"
+	"github.com/redis/go-redis/v9"
+)
+
+// pollTimeout is how long a single BLPOP waits, which also bounds how long
+// Serve takes to notice that its context was cancelled.
+const pollTimeout = 5 * time.Second
+
+type Worker struct {
+	Queue       *Queue
+	Runner      runner.Runner
+	Logger      *slog.Logger
+	Concurrency int
+}
+
+// Serve consumes jobs until ctx is cancelled, then waits for the jobs it
+// already picked up to finish before returning.
+func (w *Worker) Serve(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.recoverJobs(ctx)
+	}()
+
+	for i := 0; i < w.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (w *Worker) loop(ctx context.Context) {
+	for ctx.Err() == nil {
+		rec, err := w.Queue.dequeue(ctx, pollTimeout)
+		if err != nil {
+			switch {
+			case errors.Is(err, redis.Nil), ctx.Err() != nil:
+			case errors.Is(err, ErrJobNotFound):
+				w.Logger.Error("dequeued job has expired")
+			default:
+				w.Logger.Error("dequeue failed", "error", err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		// The job was already taken off the queue, so let it finish even
+		// if we are shutting down.
+		w.process(context.WithoutCancel(ctx), rec)
+	}
+}
+
+// recoverJobs puts the jobs of workers that died back into the queue, and
+// fails those that keep taking their workers down with them.
+func (w *Worker) recoverJobs(ctx context.Context) {
+	ticker := time.NewTicker(leaseTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		failed, err := w.Queue.recover(ctx)
+		if err != nil && ctx.Err() == nil {
+			w.Logger.Error("recovering jobs failed", "error", err)
+		}
+		for _, rec := range failed {
+			w.Logger.Error("job interrupted too often", "job", rec.Job.ID, "attempts", maxAttempts)
+			finished := time.Now()
+			rec.Job.FinishedAt = &finished
+			rec.Job.Status = StatusFailed
+			rec.Job.Error = "the server encountered a problem and could not run the job"
+			w.finish(ctx, rec)
+		}
+	}
+}
+
+func (w *Worker) process(ctx context.Context, rec *record) {
+	started := time.Now()
+	rec.Job.Status = StatusRunning
+	rec.Job.StartedAt = &started
+
+	err := w.Queue.save(ctx, rec)
+	if err != nil {
+		w.Logger.Error("saving job failed", "job", rec.Job.ID, "error", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go w.renewLease(ctx, rec, done)
+
+	result, err := w.run(ctx, rec)
+	for errors.Is(err, runner.ErrPoolFull) {
+		// The job already waited in Redis, so wait for a slot here rather
+		// than failing it.
+		time.Sleep(time.Second)
+		result, err = w.run(ctx, rec)
+	}
+
+	finished := time.Now()
+	rec.Job.FinishedAt = &finished
+	if err != nil {
+		w.Logger.Error("run failed", "job", rec.Job.ID, "error", err)
+		rec.Job.Status = StatusFailed
+		rec.Job.Error = "the server encountered a problem and could not run the job"
+	} else {
+		rec.Job.Status = StatusDone
+		rec.Job.Result = result
+	}
+
+	w.finish(ctx, rec)
+}
+
+// renewLease keeps the job's lease from running out until done is closed.
+func (w *Worker) renewLease(ctx context.Context, rec *record, done <-chan struct{}) {
+	ticker := time.NewTicker(leaseTimeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		err := w.Queue.renew(ctx, rec)
+		if err != nil {
+			w.Logger.Error("renewing job lease failed", "job", rec.Job.ID, "error", err)
+		}
+	}
+}
+
+// finish stores the finished job and publishes it as its last event.
+func (w *Worker) finish(ctx context.Context, rec *record) {
+	err := w.Queue.complete(ctx, rec)
+	if err != nil {
+		w.Logger.Error("saving job failed", "job", rec.Job.ID, "error", err)
+	}
+
+	err = w.Queue.publish(ctx, rec.Job.ID, EventResult, rec.Job)
+	if err != nil {
+		w.Logger.Error("publishing job result failed", "job", rec.Job.ID, "error", err)
+	}
+}
+
+// run executes the job's request, publishing its output to the job's event
+// stream while it runs if the Runner can stream.
+func (w *Worker) run(ctx context.Context, rec *record) (*runner.ExecuteResult, error) {
+	sr, ok := w.Runner.(runner.StreamRunner)
+	if !ok {
+		return w.Runner.Run(ctx, rec.Request)
+	}
+	return sr.RunStream(ctx, rec.Request, func(e runner.OutputEvent) {
+		err := w.Queue.publish(ctx, rec.Job.ID, e.Stream, e)
+		if err != nil {
+			w.Logger.Error("publishing job output failed", "job", rec.Job.ID, "error", err)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package judge
+
+import (
+	"strings"
+)
+
+const (
+	// maxDiffCells bounds the LCS table. Bigger outputs only get the first
+	// differing line.
+	maxDiffCells = 1 << 20
+	maxDiffBytes = 4096
+)
+
+// diff returns a line diff from expected to actual, with "-" marking
+// expected lines that are missing, "+" lines that were not expected and
+// " " lines both share.
+func diff(expected, actual string) string {
+	e := strings.Split(expected, "\n")
+	a := strings.Split(actual, "\n")
+
+	var b strings.Builder
+	if len(e)*len(a) > maxDiffCells {
+		for i := 0; i < len(e) || i < len(a); i++ {
+			if i >= len(e) || i >= len(a) || e[i] != a[i] {
+				if i < len(e) {
+					b.WriteString("-" + e[i] + "\n")
+				}
+				if i < len(a) {
+					b.WriteString("+" + a[i] + "\n")
+				}
+				break
+			}
+		}
+		return truncate(b.String())
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of e[i:]
+	// and a[j:].
+	lcs := make([][]int, len(e)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(a)+1)
+	}
+	for i := len(e) - 1; i >= 0; i-- {
+		for j := len(a) - 1; j >= 0; j-- {
+			if e[i] == a[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(e) || j < len(a) {
+		switch {
+		case i < len(e) && j < len(a) && e[i] == a[j]:
+			b.WriteString(" " + e[i] + "\n")
+			i++
+			j++
+		case j == len(a) || (i < len(e) && lcs[i+1][j] >= lcs[i][j+1]):
+			b.WriteString("-" + e[i] + "\n")
+			i++
+		default:
+			b.WriteString("+" + a[j] + "\n")
+			j++
+		}
+	}
+	return truncate(b.String())
+}
+
+func truncate(s string) string {
+	if len(s) <= maxDiffBytes {
+		return s
+	}
+	return strings.ToValidUTF8(s[:maxDiffBytes], "") + "...\n"
+}
@@ -0,0 +1,238 @@
+package judge
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VJ-2303/code-runner/internal/runner"
+	"github.com/VJ-2303/code-runner/internal/validator"
+)
+
+const (
+	MaxCases         = 32
+	MaxExpectedBytes = 64 * 1024
+
+	DefaultTolerance = 1e-6
+)
+
+// Mode is how a case's output is compared with the expected output.
+type Mode string
+
+const (
+	// ModeExact compares byte for byte.
+	ModeExact Mode = "exact"
+	// ModeWhitespace compares the whitespace separated tokens, so line
+	// endings, trailing spaces and blank lines do not matter.
+	ModeWhitespace Mode = "whitespace"
+	// ModeFloat compares tokens like ModeWhitespace, but numeric tokens
+	// only have to be within the tolerance of each other.
+	ModeFloat Mode = "float"
+)
+
+var Modes = []string{string(ModeExact), string(ModeWhitespace), string(ModeFloat)}
+
+type Verdict string
+
+const (
+	VerdictAccepted            Verdict = "accepted"
+	VerdictWrongAnswer         Verdict = "wrong_answer"
+	VerdictTimeLimitExceeded   Verdict = "time_limit_exceeded"
+	VerdictOutputLimitExceeded Verdict = "output_limit_exceeded"
+	VerdictRuntimeError        Verdict = "runtime_error"
+	VerdictCompileError        Verdict = "compile_error"
+	// VerdictInternalError means the sandbox failed, and says nothing about
+	// the submission.
+	VerdictInternalError Verdict = "internal_error"
+)
+
+type TestCase struct {
+	Stdin          string `json:"stdin"`
+	ExpectedOutput string `json:"expected_output"`
+	// TimeLimitMS overrides the request's run timeout for this case.
+	TimeLimitMS int `json:"time_limit_ms,omitempty"`
+}
+
+// Request is a submission and the cases to judge it against. Stdin of the
+// embedded request is ignored, every case brings its own. Its Limits apply
+// to every case, except for the timeout of cases that set their own.
+type Request struct {
+	runner.ExecuteRequest
+	Cases     []TestCase
+	Mode      Mode
+	Tolerance float64
+}
+
+type CaseResult struct {
+	Verdict         Verdict `json:"verdict"`
+	Output          string  `json:"output"`
+	Error           string  `json:"error"`
+	ExitCode        int     `json:"exit_code"`
+	WallTimeMS      int64   `json:"wall_time_ms"`
+	CPUTimeMS       int64   `json:"cpu_time_ms"`
+	PeakMemoryBytes int64   `json:"peak_memory_bytes"`
+	// Diff is a line diff from the expected to the actual output, set for
+	// wrong answers.
+	Diff string `json:"diff,omitempty"`
+}
+
+type Result struct {
+	// Verdict is accepted when every case is, and otherwise the verdict
+	// of the first case that was not.
+	Verdict Verdict               `json:"verdict"`
+	Passed  int                   `json:"passed"`
+	Total   int                   `json:"total"`
+	Compile *runner.ExecuteResult `json:"compile,omitempty"`
+	Cases   []CaseResult          `json:"cases"`
+}
+
+// ValidateRequest checks req, with the run limits of the request and of
+// every case within caps.
+func ValidateRequest(v *validator.Validator, req Request, caps runner.Limits) {
+	runner.ValidateExecuteRequest(v, req.ExecuteRequest)
+	runner.ValidateLimits(v, req.Limits, caps)
+
+	v.Check(len(req.Cases) > 0, "cases", "must contain at least one test case")
+	v.Check(len(req.Cases) <= MaxCases, "cases", "must not contain more than 32 test cases")
+	for _, c := range req.Cases {
+		v.Check(len(c.Stdin) <= runner.MaxStdinBytes, "cases", "stdin must not be more than 64KB")
+		v.Check(len(c.ExpectedOutput) <= MaxExpectedBytes, "cases", "expected_output must not be more than 64KB")
+		v.Check(c.TimeLimitMS >= 0, "cases", "time_limit_ms must not be negative")
+		v.Check(int64(c.TimeLimitMS) <= time.Duration(caps.Timeout).Milliseconds(), "cases", fmt.Sprintf("time_limit_ms must not be more than %d", time.Duration(caps.Timeout).Milliseconds()))
+	}
+
+	v.Check(validator.PermittedValue(string(req.Mode), Modes...), "mode", "must be exact, whitespace or float")
+	v.Check(req.Tolerance >= 0, "tolerance", "must not be negative")
+}
+
+// Judge runs a submission against test cases. Runners that are a
+// runner.BatchRunner compile it once for all cases, others once per case.
+type Judge struct {
+	runner runner.Runner
+}
+
+func New(r runner.Runner) *Judge {
+	return &Judge{runner: r}
+}
+
+// Run judges the cases one after another. A compile error ends judging
+// straight away, since it would only repeat for every other case.
+func (j *Judge) Run(ctx context.Context, req Request) (*Result, error) {
+	tolerance := req.Tolerance
+	if tolerance == 0 {
+		tolerance = DefaultTolerance
+	}
+
+	result := &Result{
+		Verdict: VerdictAccepted,
+		Total:   len(req.Cases),
+		Cases:   make([]CaseResult, 0, len(req.Cases)),
+	}
+
+	inputs := make([]runner.RunInput, len(req.Cases))
+	for i, c := range req.Cases {
+		inputs[i].Stdin = []byte(c.Stdin)
+		inputs[i].Limits = req.Limits
+		if c.TimeLimitMS > 0 {
+			inputs[i].Limits.Timeout = runner.Duration(time.Duration(c.TimeLimitMS) * time.Millisecond)
+		}
+	}
+
+	results, err := runner.RunBatch(ctx, j.runner, req.ExecuteRequest, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, res := range results {
+		c := req.Cases[i]
+		if result.Compile == nil {
+			result.Compile = res.Compile
+		}
+
+		cr := CaseResult{
+			Verdict:         verdict(res),
+			Output:          res.Output,
+			Error:           res.Error,
+			ExitCode:        res.ExitCode,
+			WallTimeMS:      res.WallTimeMS,
+			CPUTimeMS:       res.CPUTimeMS,
+			PeakMemoryBytes: res.PeakMemoryBytes,
+		}
+		if cr.Verdict == VerdictAccepted && !match(req.Mode, res.Output, c.ExpectedOutput, tolerance) {
+			cr.Verdict = VerdictWrongAnswer
+			cr.Diff = diff(c.ExpectedOutput, res.Output)
+		}
+		result.Cases = append(result.Cases, cr)
+
+		if cr.Verdict == VerdictAccepted {
+			result.Passed++
+		} else if result.Verdict == VerdictAccepted {
+			result.Verdict = cr.Verdict
+		}
+		if cr.Verdict == VerdictCompileError {
+			break
+		}
+	}
+	return result, nil
+}
+
+// verdict maps how a run ended onto a verdict, leaving the output to be
+// checked when the program exited normally. A failed dependency install
+// counts as a compile error, as the program never got to run.
+func verdict(res *runner.ExecuteResult) Verdict {
+	switch res.Status {
+	case runner.StatusOK:
+		return VerdictAccepted
+	case runner.StatusTimeout:
+		return VerdictTimeLimitExceeded
+	case runner.StatusOutputLimitExceeded:
+		return VerdictOutputLimitExceeded
+	case runner.StatusCompileError, runner.StatusDependencyError:
+		return VerdictCompileError
+	case runner.StatusInternalError:
+		return VerdictInternalError
+	}
+	return VerdictRuntimeError
+}
+
+func match(mode Mode, actual, expected string, tolerance float64) bool {
+	switch mode {
+	case ModeWhitespace:
+		return tokensEqual(actual, expected, func(a, b string) bool { return a == b })
+	case ModeFloat:
+		return tokensEqual(actual, expected, func(a, b string) bool {
+			return a == b || floatsClose(a, b, tolerance)
+		})
+	}
+	return actual == expected
+}
+
+func tokensEqual(actual, expected string, equal func(a, b string) bool) bool {
+	a, e := strings.Fields(actual), strings.Fields(expected)
+	if len(a) != len(e) {
+		return false
+	}
+	for i := range a {
+		if !equal(a[i], e[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// floatsClose accepts an absolute or relative error within tolerance, so
+// that both tiny and huge expected values can be checked with one setting.
+func floatsClose(actual, expected string, tolerance float64) bool {
+	a, err := strconv.ParseFloat(actual, 64)
+	if err != nil || math.IsNaN(a) {
+		return false
+	}
+	e, err := strconv.ParseFloat(expected, 64)
+	if err != nil || math.IsNaN(e) {
+		return false
+	}
+	return math.Abs(a-e) <= tolerance*math.Max(1, math.Abs(e))
+}
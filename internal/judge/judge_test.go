@@ -0,0 +1,123 @@
+package judge
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/VJ-2303/code-runner/internal/runner"
+	"github.com/VJ-2303/code-runner/internal/validator"
+)
+
+// echoRunner prints each run's stdin back, or fails to compile.
+type echoRunner struct {
+	compileError bool
+	runs         int
+}
+
+func (r *echoRunner) Run(ctx context.Context, req runner.ExecuteRequest) (*runner.ExecuteResult, error) {
+	r.runs++
+	if r.compileError {
+		return &runner.ExecuteResult{Status: runner.StatusCompileError, Compile: &runner.ExecuteResult{Status: runner.StatusCompileError}}, nil
+	}
+	return &runner.ExecuteResult{Status: runner.StatusOK, Output: string(req.Stdin)}, nil
+}
+
+// batchRunner compiles once per batch.
+type batchRunner struct {
+	echoRunner
+	batches int
+}
+
+func (r *batchRunner) RunBatch(ctx context.Context, req runner.ExecuteRequest, inputs []runner.RunInput) ([]*runner.ExecuteResult, error) {
+	r.batches++
+	var results []*runner.ExecuteResult
+	for _, in := range inputs {
+		run := req
+		run.Stdin = in.Stdin
+		res, _ := r.Run(ctx, run)
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func testRequest() Request {
+	return Request{
+		ExecuteRequest: runner.ExecuteRequest{Language: "c", Code: "int main() {}"},
+		Cases: []TestCase{
+			{Stdin: "1\n", ExpectedOutput: "1"},
+			{Stdin: "2\n", ExpectedOutput: "3"},
+			{Stdin: "3\n", ExpectedOutput: "3"},
+		},
+		Mode: ModeWhitespace,
+	}
+}
+
+func TestJudgeUsesBatchRunner(t *testing.T) {
+	r := &batchRunner{}
+	res, err := New(r).Run(context.Background(), testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.batches != 1 {
+		t.Errorf("got %d batches, want 1", r.batches)
+	}
+	if res.Verdict != VerdictWrongAnswer || res.Passed != 2 || len(res.Cases) != 3 {
+		t.Errorf("got verdict %s with %d of %d cases passed", res.Verdict, res.Passed, len(res.Cases))
+	}
+}
+
+func TestJudgeStopsAtCompileError(t *testing.T) {
+	r := &echoRunner{compileError: true}
+	res, err := New(r).Run(context.Background(), testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.runs != 1 {
+		t.Errorf("compiled %d times, want once", r.runs)
+	}
+	if res.Verdict != VerdictCompileError || len(res.Cases) != 1 || res.Compile == nil {
+		t.Errorf("got verdict %s with %d cases", res.Verdict, len(res.Cases))
+	}
+}
+
+func TestValidateRequestLimits(t *testing.T) {
+	caps := runner.Limits{Timeout: runner.Duration(5 * time.Second), MemoryMB: 256, CPUs: 1}
+
+	tests := []struct {
+		name   string
+		limits runner.Limits
+		caseMS int
+		errors []string
+	}{
+		{name: "defaults"},
+		{name: "within caps", limits: runner.Limits{MemoryMB: 64, CPUs: 0.5}, caseMS: 5000},
+		{name: "case over the cap", caseMS: 5001, errors: []string{"cases"}},
+		{name: "case too large for a duration", caseMS: math.MaxInt64, errors: []string{"cases"}},
+		{name: "memory over the cap", limits: runner.Limits{MemoryMB: 512}, errors: []string{"memory_mb"}},
+		{name: "too little memory", limits: runner.Limits{MemoryMB: 1}, errors: []string{"memory_mb"}},
+		{name: "too few cpus", limits: runner.Limits{CPUs: 0.0001}, errors: []string{"cpus"}},
+		{name: "timeout over the cap", limits: runner.Limits{Timeout: runner.Duration(time.Minute)}, errors: []string{"time_limit_ms"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testRequest()
+			req.Limits = tt.limits
+			req.Cases[0].TimeLimitMS = tt.caseMS
+
+			v := validator.New()
+			ValidateRequest(v, req, caps)
+
+			if len(v.FieldErrors) != len(tt.errors) {
+				t.Fatalf("got errors %v, want errors for %v", v.FieldErrors, tt.errors)
+			}
+			for _, key := range tt.errors {
+				if _, ok := v.FieldErrors[key]; !ok {
+					t.Errorf("got errors %v, want one for %s", v.FieldErrors, key)
+				}
+			}
+		})
+	}
+}
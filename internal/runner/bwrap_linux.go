@@ -0,0 +1,388 @@
+//go:build linux
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	cgroupPrefix = "runner-"
+	cpuPeriod    = 100000
+
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+// sandboxDirs are created by bwrap itself rather than taken from the rootfs.
+var sandboxDirs = []string{"proc", "dev", "sys", "tmp", "app", "src", "runner"}
+
+// bwrapEngine runs each phase under bubblewrap in fresh user, mount, pid,
+// network, ipc and uts namespaces. bwrap is started directly inside a new
+// cgroup, so the limits apply from the first instruction and the cgroup can
+// be killed as a whole.
+type bwrapEngine struct {
+	bwrap      string
+	rootfsDir  string
+	cgroupRoot string
+}
+
+func newBwrapEngine(opts NamespaceOptions) (containerEngine, error) {
+	bwrap := opts.Bwrap
+	if bwrap == "" {
+		bwrap = "bwrap"
+	}
+	path, err := exec.LookPath(bwrap)
+	if err != nil {
+		return nil, fmt.Errorf("bubblewrap not found: %w", err)
+	}
+
+	info, err := os.Stat(opts.RootfsDir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", opts.RootfsDir)
+	}
+
+	// Child cgroups only get the controllers their parent enables for them.
+	err = os.WriteFile(filepath.Join(opts.CgroupRoot, "cgroup.subtree_control"), []byte("+cpu +memory +pids"), 0)
+	if err != nil {
+		return nil, fmt.Errorf("enabling cgroup controllers in %s: %w", opts.CgroupRoot, err)
+	}
+
+	return &bwrapEngine{
+		bwrap:      path,
+		rootfsDir:  opts.RootfsDir,
+		cgroupRoot: opts.CgroupRoot,
+	}, nil
+}
+
+func (e *bwrapEngine) run(ctx context.Context, spec containerSpec) (*containerState, error) {
+	if spec.networkMode() != "none" {
+		return nil, errors.New("the namespace runner has no network access")
+	}
+	image := imageDir(spec.image)
+
+	env, err := e.imageEnv(image)
+	if err != nil {
+		return nil, err
+	}
+
+	cg, err := e.createCgroup(spec.name, spec.limits, spec.security)
+	if err != nil {
+		return nil, err
+	}
+	defer removeCgroup(cg)
+
+	args, err := e.args(filepath.Join(e.rootfsDir, image), cg, spec, mergeEnv(env, spec.env))
+	if err != nil {
+		return nil, err
+	}
+
+	cgFD, err := os.Open(cg)
+	if err != nil {
+		return nil, err
+	}
+	defer cgFD.Close()
+
+	infoR, infoW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer infoR.Close()
+
+	cmd := exec.CommandContext(ctx, e.bwrap, args...)
+	// The run's environment only exists inside the sandbox, see args, so it
+	// cannot change how bwrap itself runs.
+	cmd.Env = []string{"PATH=" + defaultPath}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:     true,
+		UseCgroupFD: true,
+		CgroupFD:    int(cgFD.Fd()),
+	}
+	cmd.Cancel = func() error {
+		killCgroup(cg)
+		return cmd.Process.Kill()
+	}
+	cmd.WaitDelay = 5 * time.Second
+	if len(spec.stdin) > 0 {
+		cmd.Stdin = bytes.NewReader(spec.stdin)
+	}
+	cmd.Stdout = spec.stdout
+	cmd.Stderr = spec.stderr
+	cmd.ExtraFiles = []*os.File{infoW}
+
+	startedAt := time.Now()
+	err = cmd.Start()
+	infoW.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Wait()
+	finishedAt := time.Now()
+
+	// Nothing may outlive the phase, just like a removed container.
+	killCgroup(cg)
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+
+	// bwrap reports on the info fd once the sandbox is set up, so silence
+	// there means it failed before running anything.
+	info, _ := io.ReadAll(infoR)
+	if len(info) == 0 {
+		return nil, errors.New("sandbox did not start")
+	}
+
+	return &containerState{
+		ExitCode:   exitCode(cmd.ProcessState),
+		OOMKilled:  cgroupOOMKills(cg) > 0,
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+	}, nil
+}
+
+// args builds the bwrap command line. The rootfs is bound directory by
+// directory onto an empty root, so that the mount points bwrap creates for
+// /app, /src and /runner never touch the shared rootfs. The command runs
+// with env and nothing else.
+func (e *bwrapEngine) args(rootfs, cg string, spec containerSpec, env map[string]string) ([]string, error) {
+	entries, err := os.ReadDir(rootfs)
+	if err != nil {
+		return nil, fmt.Errorf("no rootfs for image %s: %w", spec.image, err)
+	}
+
+	args := []string{
+		"--unshare-all",
+		"--die-with-parent",
+		"--new-session",
+		"--hostname", "sandbox",
+		"--info-fd", "3",
+		"--clearenv",
+	}
+	for _, key := range slices.Sorted(maps.Keys(env)) {
+		args = append(args, "--setenv", key, env[key])
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case slices.Contains(sandboxDirs, name):
+		case entry.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(filepath.Join(rootfs, name))
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, "--symlink", target, "/"+name)
+		case entry.IsDir():
+			args = append(args, "--ro-bind", filepath.Join(rootfs, name), "/"+name)
+		}
+	}
+
+	// tmpfs pages are charged to the phase's cgroup, so memory.max bounds
+	// these as well, on top of their own sizes.
+	sp := spec.security
+	args = append(args, "--proc", "/proc", "--dev", "/dev")
+	args = append(args, tmpfsArgs("/tmp", sp.TmpSizeMB)...)
+	args = append(args, tmpfsArgs("/app", sp.WorkDirSizeMB)...)
+	for _, m := range spec.mounts {
+		if m.readOnly {
+			args = append(args, "--ro-bind", m.source, m.target)
+		} else {
+			args = append(args, "--bind", m.source, m.target)
+		}
+	}
+	// The wrapper script reads its counters from here.
+	args = append(args, "--ro-bind", cg, "/sys/fs/cgroup")
+
+	if sp.User != "" {
+		uid, gid, _ := strings.Cut(sp.User, ":")
+		args = append(args, "--unshare-user", "--uid", uid)
+		if gid != "" {
+			args = append(args, "--gid", gid)
+		}
+	}
+	if sp.DropAllCaps {
+		args = append(args, "--cap-drop", "ALL")
+	}
+
+	args = append(args, "--remount-ro", "/", "--chdir", "/app", "--")
+	return append(args, withRlimits(spec.command, sp)...), nil
+}
+
+// tmpfsArgs mounts a world-writable tmpfs at target, of at most sizeMB
+// unless that is zero.
+func tmpfsArgs(target string, sizeMB int) []string {
+	args := []string{"--perms", "1777"}
+	if sizeMB > 0 {
+		args = append(args, "--size", strconv.Itoa(sizeMB*1024*1024))
+	}
+	return append(args, "--tmpfs", target)
+}
+
+// withRlimits puts a shell in front of command that applies the profile's
+// rlimits, since bwrap cannot set them itself.
+func withRlimits(command []string, sp SecurityProfile) []string {
+	fail := fmt.Sprintf(`{ echo "setup_failed 1" > %s; exit 1; }`, statsMountPath)
+
+	var script strings.Builder
+	if sp.NofileLimit > 0 {
+		fmt.Fprintf(&script, "ulimit -n %d || %s\n", sp.NofileLimit, fail)
+	}
+	if sp.FsizeMB > 0 {
+		// POSIX shells count the file size limit in 512 byte blocks.
+		fmt.Fprintf(&script, "ulimit -f %d || %s\n", sp.FsizeMB*2048, fail)
+	}
+	if script.Len() == 0 {
+		return command
+	}
+	script.WriteString(`exec "$@"`)
+	return append([]string{"sh", "-c", script.String(), "sh"}, command...)
+}
+
+// imageEnv returns the environment baked into image, which "docker export"
+// does not keep, from the optional .env file next to its rootfs.
+func (e *bwrapEngine) imageEnv(image string) (map[string]string, error) {
+	env := map[string]string{"PATH": defaultPath}
+
+	b, err := os.ReadFile(filepath.Join(e.rootfsDir, image+".env"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return env, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if ok && key != "" {
+			env[key] = value
+		}
+	}
+	return env, nil
+}
+
+// createCgroup creates the cgroup for one phase. Swap is disabled so that
+// memory.max is a hard limit.
+func (e *bwrapEngine) createCgroup(name string, limits Limits, sp SecurityProfile) (string, error) {
+	dir := filepath.Join(e.cgroupRoot, name)
+	err := os.Mkdir(dir, 0o755)
+	if err != nil {
+		return "", fmt.Errorf("creating cgroup: %w", err)
+	}
+
+	settings := [][2]string{
+		{"memory.max", strconv.FormatInt(limits.memoryBytes(), 10)},
+		{"memory.swap.max", "0"},
+		{"cpu.max", fmt.Sprintf("%d %d", int64(limits.CPUs*cpuPeriod), cpuPeriod)},
+	}
+	if sp.PidsLimit > 0 {
+		settings = append(settings, [2]string{"pids.max", strconv.Itoa(sp.PidsLimit)})
+	}
+
+	for _, s := range settings {
+		err := os.WriteFile(filepath.Join(dir, s[0]), []byte(s[1]), 0)
+		// memory.swap.max is missing when the kernel has no swap accounting.
+		if errors.Is(err, fs.ErrNotExist) && s[0] == "memory.swap.max" {
+			continue
+		}
+		if err != nil {
+			removeCgroup(dir)
+			return "", fmt.Errorf("setting %s: %w", s[0], err)
+		}
+	}
+	return dir, nil
+}
+
+func killCgroup(dir string) {
+	os.WriteFile(filepath.Join(dir, "cgroup.kill"), []byte("1"), 0)
+}
+
+// removeCgroup kills whatever is left in dir and removes it. A cgroup can
+// only be removed once its last process has exited, which takes a moment
+// after the kill.
+func removeCgroup(dir string) error {
+	killCgroup(dir)
+
+	var err error
+	for range 50 {
+		err = os.Remove(dir)
+		if err == nil || errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return err
+}
+
+func cgroupOOMKills(dir string) int64 {
+	b, err := os.ReadFile(filepath.Join(dir, "memory.events"))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if value, ok := strings.CutPrefix(line, "oom_kill "); ok {
+			n, _ := strconv.ParseInt(value, 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+// exitCode reports a signal the way a shell would, as 128 plus its number.
+func exitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
+
+func imageDir(image string) string {
+	return strings.NewReplacer("/", "_", ":", "_", "@", "_").Replace(image)
+}
+
+// reap removes phase cgroups left behind by a crash, killing anything that
+// still runs in them.
+func (e *bwrapEngine) reap(ctx context.Context, olderThan time.Duration) (int, error) {
+	entries, err := os.ReadDir(e.cgroupRoot)
+	if err != nil {
+		return 0, fmt.Errorf("listing sandbox cgroups: %w", err)
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	n := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), cgroupPrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		err = removeCgroup(filepath.Join(e.cgroupRoot, entry.Name()))
+		if err != nil {
+			return n, fmt.Errorf("removing sandbox cgroups: %w", err)
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package runner
+
+import "errors"
+
+func newBwrapEngine(opts NamespaceOptions) (containerEngine, error) {
+	return nil, errors.New("the namespace runner is only available on Linux")
+}
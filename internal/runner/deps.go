@@ -0,0 +1,440 @@
+package runner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VJ-2303/code-runner/internal/validator"
+)
+
+// DepsOptions enables dependency installs for languages that define
+// Dependencies. Without it a manifest is just another file.
+type DepsOptions struct {
+	// CacheDir keeps one installed /deps directory per manifest.
+	CacheDir string
+	// Network is the container network of install phases. It should only
+	// reach Mirror, for example an internal Docker network that the mirror
+	// is attached to.
+	Network string
+	// Mirror is the base URL of the package mirror, filled in for {mirror}.
+	Mirror string
+	// MaxCacheMB bounds the size of CacheDir. Once an install takes it
+	// past the limit, the least recently used manifests are removed. Zero
+	// disables the limit.
+	MaxCacheMB int
+	// InstallsPerHour bounds how many manifests that are not cached yet
+	// each user may install per hour. Zero disables the limit.
+	InstallsPerHour int
+}
+
+const (
+	depsMountPath = "/deps"
+
+	// depsInUseGrace is how long after its last use a cached manifest may
+	// still be mounted by a phase or session, and so is never evicted.
+	depsInUseGrace = time.Hour
+)
+
+// manifestNames are the manifests ValidateDependencies knows how to check.
+var manifestNames = []string{"requirements.txt", "package.json", "Gemfile"}
+
+// ValidateDependencies rejects manifests that could fetch code from anywhere
+// but the package mirror: URLs, VCS repositories and local paths, along with
+// options that would change the index.
+func ValidateDependencies(v *validator.Validator, lang Language, files []File) {
+	if lang.Dependencies == nil {
+		return
+	}
+	for _, f := range files {
+		if f.Path != lang.Dependencies.Manifest {
+			continue
+		}
+		err := checkManifest(f.Path, f.Content)
+		if err != nil {
+			v.AddError("files", err.Error())
+		}
+	}
+}
+
+func checkManifest(name, content string) error {
+	switch name {
+	case "requirements.txt":
+		return checkRequirements(content)
+	case "package.json":
+		return checkPackageJSON(content)
+	case "Gemfile":
+		return checkGemfile(content)
+	}
+	return fmt.Errorf("%s is not a supported manifest", name)
+}
+
+var requirementRX = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*(\[[A-Za-z0-9._,\s-]*\])?\s*([<>=!~]=?=?\s*[A-Za-z0-9.*+!-]+(\s*,\s*[<>=!~]=?=?\s*[A-Za-z0-9.*+!-]+)*)?\s*(;[^@/:]*)?$`)
+
+// checkRequirements accepts lines naming a package with optional extras,
+// version specifiers and environment markers, nothing else.
+func checkRequirements(content string) error {
+	for i, line := range strings.Split(content, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !requirementRX.MatchString(line) {
+			return fmt.Errorf("requirements.txt line %d: only package names with version specifiers are allowed, no options, URLs or paths", i+1)
+		}
+	}
+	return nil
+}
+
+var npmRangeRX = regexp.MustCompile(`^[A-Za-z0-9.^~*<>=|+\s-]*$`)
+
+// checkPackageJSON accepts semver ranges and dist-tags. Anything with a ':'
+// or '/', such as "file:", "git+https:" or a "user/repo" GitHub shorthand,
+// is rejected.
+func checkPackageJSON(content string) error {
+	var pkg struct {
+		Dependencies         map[string]string `json:"dependencies"`
+		DevDependencies      map[string]string `json:"devDependencies"`
+		OptionalDependencies map[string]string `json:"optionalDependencies"`
+		PeerDependencies     map[string]string `json:"peerDependencies"`
+	}
+	err := json.Unmarshal([]byte(content), &pkg)
+	if err != nil {
+		return errors.New("package.json must be valid JSON")
+	}
+
+	for _, deps := range []map[string]string{pkg.Dependencies, pkg.DevDependencies, pkg.OptionalDependencies, pkg.PeerDependencies} {
+		for name, version := range deps {
+			if !npmRangeRX.MatchString(version) {
+				return fmt.Errorf("package.json: %q must be a version range, not a URL, alias or path", name)
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	gemSourceRX = regexp.MustCompile(`^source\s+["']https://rubygems\.org/?["']$`)
+	gemRubyRX   = regexp.MustCompile(`^ruby\s+["'][0-9.]+["']$`)
+	gemGroupRX  = regexp.MustCompile(`^group\s+:[a-z_]+(\s*,\s*:[a-z_]+)*\s+do$`)
+	gemRX       = regexp.MustCompile(`^gem\s+("[A-Za-z0-9._-]+"|'[A-Za-z0-9._-]+')(\s*,\s*("[~<>=!\s0-9A-Za-z.]*"|'[~<>=!\s0-9A-Za-z.]*'))*(\s*,\s*require:\s*(false|true|"[A-Za-z0-9/._-]*"|'[A-Za-z0-9/._-]*'))?$`)
+)
+
+// checkGemfile only accepts a small declarative subset of the Gemfile
+// language, since bundler evaluates the file as Ruby during the install.
+func checkGemfile(content string) error {
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch {
+		case line == "end", gemSourceRX.MatchString(line), gemRubyRX.MatchString(line),
+			gemGroupRX.MatchString(line), gemRX.MatchString(line):
+		default:
+			return fmt.Errorf(`Gemfile line %d: only rubygems.org as source and gem lines with versions are allowed, no git, github or path options`, i+1)
+		}
+	}
+	return nil
+}
+
+var requirementNameRX = regexp.MustCompile(`^[a-z0-9._-]+`)
+
+// normalizeManifest rewrites a manifest so that ones which install the same
+// packages hash the same: comments, blank lines and whitespace are dropped,
+// requirements.txt lines are sorted with their package names in canonical
+// form, and package.json is re-encoded with sorted keys.
+func normalizeManifest(name, content string) string {
+	var lines []string
+	switch name {
+	case "requirements.txt":
+		for _, line := range strings.Split(content, "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			line = strings.ToLower(strings.Join(strings.Fields(line), ""))
+			if line == "" {
+				continue
+			}
+			pkg := requirementNameRX.FindString(line)
+			canonical := strings.Join(strings.FieldsFunc(pkg, func(r rune) bool { return r == '-' || r == '_' || r == '.' }), "-")
+			lines = append(lines, canonical+line[len(pkg):])
+		}
+		slices.Sort(lines)
+		return strings.Join(slices.Compact(lines), "\n")
+	case "package.json":
+		var pkg any
+		if json.Unmarshal([]byte(content), &pkg) != nil {
+			return content
+		}
+		b, err := json.Marshal(pkg)
+		if err != nil {
+			return content
+		}
+		return string(b)
+	case "Gemfile":
+		for _, line := range strings.Split(content, "\n") {
+			line = strings.Join(strings.Fields(line), " ")
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			lines = append(lines, line)
+		}
+		return strings.Join(lines, "\n")
+	}
+	return content
+}
+
+// depsCache hands out installed dependency directories, keyed by a hash of
+// everything that goes into the install.
+type depsCache struct {
+	DepsOptions
+
+	mu      sync.Mutex
+	pending map[string]*sync.Mutex
+	// installs holds the start times of each user's installs in the last
+	// hour.
+	installs map[int64][]time.Time
+}
+
+func newDepsCache(opts DepsOptions) (*depsCache, error) {
+	if opts.Network == "" || opts.Mirror == "" {
+		return nil, errors.New("dependency installs need a network and a package mirror")
+	}
+	err := os.MkdirAll(opts.CacheDir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("creating dependency cache: %w", err)
+	}
+	return &depsCache{
+		DepsOptions: opts,
+		pending:     make(map[string]*sync.Mutex),
+		installs:    make(map[int64][]time.Time),
+	}, nil
+}
+
+func (c *depsCache) key(lang Language, manifest string) string {
+	h := sha256.New()
+	for _, s := range []string{lang.ID, lang.Image, strings.Join(lang.Dependencies.InstallCommand, "\x00"), normalizeManifest(lang.Dependencies.Manifest, manifest)} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lock serialises installs of the same key within this process, so that
+// concurrent runs with one manifest install it only once.
+func (c *depsCache) lock(key string) func() {
+	c.mu.Lock()
+	m, ok := c.pending[key]
+	if !ok {
+		m = &sync.Mutex{}
+		c.pending[key] = m
+	}
+	c.mu.Unlock()
+
+	m.Lock()
+	return func() {
+		m.Unlock()
+		c.mu.Lock()
+		delete(c.pending, key)
+		c.mu.Unlock()
+	}
+}
+
+// allowInstall records an install by userID, unless the user already
+// reached InstallsPerHour.
+func (c *depsCache) allowInstall(userID int64) bool {
+	if c.InstallsPerHour <= 0 {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cutoff := time.Now().Add(-time.Hour)
+	recent := slices.DeleteFunc(c.installs[userID], func(t time.Time) bool { return t.Before(cutoff) })
+	if len(recent) >= c.InstallsPerHour {
+		c.installs[userID] = recent
+		return false
+	}
+	c.installs[userID] = append(recent, time.Now())
+	return true
+}
+
+// touchDeps marks dir as just used, for evict.
+func touchDeps(dir string) {
+	now := time.Now()
+	os.Chtimes(dir, now, now)
+}
+
+// evict removes the least recently used manifests until the cache fits in
+// MaxCacheMB again. Manifests used within depsInUseGrace and keep are never
+// removed, even if the cache then stays too big for a while.
+func (c *depsCache) evict(keep string) {
+	if c.MaxCacheMB <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(c.CacheDir)
+	if err != nil {
+		return
+	}
+
+	type cached struct {
+		dir  string
+		used time.Time
+		size int64
+	}
+	var all []cached
+	var total int64
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join(c.CacheDir, e.Name())
+		size := dirSize(dir)
+		all = append(all, cached{dir: dir, used: info.ModTime(), size: size})
+		total += size
+	}
+	slices.SortFunc(all, func(a, b cached) int { return a.used.Compare(b.used) })
+
+	for _, e := range all {
+		if total <= int64(c.MaxCacheMB)<<20 {
+			return
+		}
+		if e.dir == keep || time.Since(e.used) < depsInUseGrace {
+			continue
+		}
+		// Renaming first takes the manifest out of the cache at once, so
+		// no run picks up a half-removed directory.
+		trash, err := os.MkdirTemp(c.CacheDir, ".evict-*")
+		if err != nil {
+			return
+		}
+		err = os.Rename(e.dir, filepath.Join(trash, "deps"))
+		os.RemoveAll(trash)
+		if err == nil {
+			total -= e.size
+		}
+	}
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+func (c *depsCache) installEnv(deps *Dependencies) map[string]string {
+	env := maps.Clone(deps.InstallEnv)
+	for k, v := range env {
+		env[k] = strings.ReplaceAll(v, "{mirror}", strings.TrimSuffix(c.Mirror, "/"))
+	}
+	return env
+}
+
+// dependencies returns the cache directory holding the installed packages
+// of the manifest among files, running an install phase first if it is not
+// cached yet. The install's result is returned when one ran; a failed
+// install returns no directory and is not cached. Users that reached
+// InstallsPerHour get a failed install without one running.
+func (s *sandbox) dependencies(ctx context.Context, tmpDir string, lang Language, files []File, userID int64) (string, *ExecuteResult, error) {
+	if s.deps == nil || lang.Dependencies == nil {
+		return "", nil, nil
+	}
+	var manifest *File
+	for i := range files {
+		if files[i].Path == lang.Dependencies.Manifest {
+			manifest = &files[i]
+		}
+	}
+	if manifest == nil {
+		return "", nil, nil
+	}
+
+	key := s.deps.key(lang, manifest.Content)
+	dir := filepath.Join(s.deps.CacheDir, key)
+	if _, err := os.Stat(dir); err == nil {
+		touchDeps(dir)
+		return dir, nil, nil
+	}
+
+	unlock := s.deps.lock(key)
+	defer unlock()
+	if _, err := os.Stat(dir); err == nil {
+		touchDeps(dir)
+		return dir, nil, nil
+	}
+
+	if !s.deps.allowInstall(userID) {
+		return "", &ExecuteResult{
+			Status:   StatusDependencyError,
+			ExitCode: -1,
+			Error:    fmt.Sprintf("Too many dependency installs: at most %d new manifests per hour", s.deps.InstallsPerHour),
+		}, nil
+	}
+
+	installDir, err := os.MkdirTemp(s.deps.CacheDir, ".install-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create install dir: %w", err)
+	}
+	defer os.RemoveAll(installDir)
+	if err := os.Chmod(installDir, 0o777); err != nil {
+		return "", nil, fmt.Errorf("failed to create install dir: %w", err)
+	}
+
+	// The packages belong to the sandbox user, so they are opened up for
+	// this process to move and eventually delete them.
+	command := append([]string{"sh", "-c", `"$@"; status=$?; chmod -R a+rwX /deps; exit $status`, "sh"}, lang.Dependencies.InstallCommand...)
+
+	result, err := s.runPhase(ctx, tmpDir, phase{
+		image:   lang.Image,
+		command: command,
+		limits:  lang.Dependencies.InstallLimits,
+		env:     s.deps.installEnv(lang.Dependencies),
+		network: s.deps.Network,
+		mounts:  []mount{{source: installDir, target: depsMountPath}},
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if result.Status != StatusOK {
+		return "", result, nil
+	}
+
+	// Another process sharing the cache may have won the race, in which
+	// case its directory is just as good.
+	err = os.Rename(installDir, dir)
+	if err != nil {
+		if _, statErr := os.Stat(dir); statErr != nil {
+			return "", nil, fmt.Errorf("failed to store dependencies: %w", err)
+		}
+	}
+	touchDeps(dir)
+	s.deps.evict(dir)
+	return dir, result, nil
+}
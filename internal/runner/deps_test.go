@@ -0,0 +1,86 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNormalizeManifest(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"requirements.txt", "requests==2.32.3\nnumpy>=2\n", "# deps\nNumPy >= 2\n\nrequests == 2.32.3  # http\n"},
+		{"requirements.txt", "typing_extensions\n", "typing-extensions\ntyping.extensions\n"},
+		{"package.json", `{"dependencies": {"left-pad": "1.3.0", "lodash": "^4"}}`, "{\n  \"dependencies\": {\n    \"lodash\": \"^4\",\n    \"left-pad\": \"1.3.0\"\n  }\n}\n"},
+		{"Gemfile", "source \"https://rubygems.org\"\ngem \"rake\"\n", "# frozen\nsource  \"https://rubygems.org\"\n\n  gem \"rake\"\n"},
+	}
+	for _, tt := range tests {
+		if a, b := normalizeManifest(tt.name, tt.a), normalizeManifest(tt.name, tt.b); a != b {
+			t.Errorf("%s: %q and %q normalise to %q and %q", tt.name, tt.a, tt.b, a, b)
+		}
+	}
+
+	if normalizeManifest("requirements.txt", "requests==2.32.3") == normalizeManifest("requirements.txt", "requests==2.32.4") {
+		t.Error("different versions normalise to the same manifest")
+	}
+}
+
+func TestDepsCacheAllowInstall(t *testing.T) {
+	c := &depsCache{DepsOptions: DepsOptions{InstallsPerHour: 2}, installs: make(map[int64][]time.Time)}
+
+	for i, want := range []bool{true, true, false} {
+		if got := c.allowInstall(1); got != want {
+			t.Errorf("install %d of user 1: got %v, want %v", i+1, got, want)
+		}
+	}
+	if !c.allowInstall(2) {
+		t.Error("user 2 was limited by the installs of user 1")
+	}
+
+	c.installs[1] = []time.Time{time.Now().Add(-2 * time.Hour), time.Now().Add(-90 * time.Minute)}
+	if !c.allowInstall(1) {
+		t.Error("installs older than an hour still count")
+	}
+}
+
+func TestDepsCacheEvict(t *testing.T) {
+	dir := t.TempDir()
+	c := &depsCache{DepsOptions: DepsOptions{CacheDir: dir, MaxCacheMB: 2}}
+
+	// Each manifest holds 1MB. "recent" was used within the grace period,
+	// "new" was just installed.
+	used := map[string]time.Time{
+		"oldest": time.Now().Add(-3 * time.Hour),
+		"older":  time.Now().Add(-2 * time.Hour),
+		"recent": time.Now().Add(-time.Minute),
+		"new":    time.Now(),
+	}
+	for name, at := range used {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(path, "pkg"), make([]byte, 1<<20), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.evict(filepath.Join(dir, "new"))
+
+	for name, want := range map[string]bool{"oldest": false, "older": false, "recent": true, "new": true} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if got := err == nil; got != want {
+			t.Errorf("%s kept: got %v, want %v", name, got, want)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("cache holds %d entries, want 2", len(entries))
+	}
+}
@@ -0,0 +1,424 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultDockerHost = "unix:///var/run/docker.sock"
+
+	// dockerAPIVersion is the oldest Engine API that has everything we use.
+	dockerAPIVersion = "v1.41"
+)
+
+// apiEngine talks to the Docker Engine HTTP API over a unix socket, which
+// saves forking a docker CLI process for every run.
+type apiEngine struct {
+	socket string
+	client *http.Client
+}
+
+func newAPIEngine(host string) (*apiEngine, error) {
+	if host == "" {
+		host = DefaultDockerHost
+	}
+	socket, ok := strings.CutPrefix(host, "unix://")
+	if !ok || socket == "" {
+		return nil, fmt.Errorf("docker host %q must be a unix:// socket", host)
+	}
+
+	e := &apiEngine{socket: socket}
+	e.client = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return e.dial(ctx)
+			},
+		},
+	}
+	return e, nil
+}
+
+func (e *apiEngine) dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", e.socket)
+}
+
+// apiError is an error response from the daemon.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (err *apiError) Error() string {
+	return fmt.Sprintf("docker: %s (status %d)", err.message, err.status)
+}
+
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.status == http.StatusNotFound
+}
+
+func apiURL(path string, query url.Values) string {
+	u := "http://docker/" + dockerAPIVersion + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// do sends a request with body encoded as JSON and decodes the response
+// into dst, when either is not nil.
+func (e *apiEngine) do(ctx context.Context, method, path string, query url.Values, body, dst any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, apiURL(path, query), r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return readAPIError(resp)
+	}
+	if dst == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+func readAPIError(resp *http.Response) error {
+	var body struct {
+		Message string `json:"message"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(b, &body) != nil || body.Message == "" {
+		body.Message = strings.TrimSpace(string(b))
+	}
+	return &apiError{status: resp.StatusCode, message: body.Message}
+}
+
+type createConfig struct {
+	Image        string            `json:"Image"`
+	Cmd          []string          `json:"Cmd"`
+	Env          []string          `json:"Env"`
+	WorkingDir   string            `json:"WorkingDir"`
+	User         string            `json:"User,omitempty"`
+	Labels       map[string]string `json:"Labels"`
+	AttachStdin  bool              `json:"AttachStdin"`
+	AttachStdout bool              `json:"AttachStdout"`
+	AttachStderr bool              `json:"AttachStderr"`
+	OpenStdin    bool              `json:"OpenStdin"`
+	StdinOnce    bool              `json:"StdinOnce"`
+	HostConfig   hostConfig        `json:"HostConfig"`
+}
+
+type hostConfig struct {
+	NetworkMode    string            `json:"NetworkMode"`
+	Memory         int64             `json:"Memory"`
+	NanoCpus       int64             `json:"NanoCpus"`
+	Binds          []string          `json:"Binds"`
+	ReadonlyRootfs bool              `json:"ReadonlyRootfs"`
+	Tmpfs          map[string]string `json:"Tmpfs,omitempty"`
+	CapDrop        []string          `json:"CapDrop,omitempty"`
+	SecurityOpt    []string          `json:"SecurityOpt,omitempty"`
+	PidsLimit      int64             `json:"PidsLimit,omitempty"`
+	Ulimits        []ulimit          `json:"Ulimits,omitempty"`
+	Runtime        string            `json:"Runtime,omitempty"`
+}
+
+// createConfig mirrors what cliEngine passes to "docker run".
+func (e *apiEngine) createConfig(spec containerSpec) (*createConfig, error) {
+	sp := spec.security
+
+	cfg := &createConfig{
+		Image:        spec.image,
+		Cmd:          spec.command,
+		Env:          envList(spec.env),
+		WorkingDir:   "/app",
+		User:         sp.User,
+		Labels:       spec.labels(),
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		OpenStdin:    true,
+		StdinOnce:    true,
+		HostConfig: hostConfig{
+			NetworkMode:    spec.networkMode(),
+			Memory:         spec.limits.memoryBytes(),
+			NanoCpus:       spec.limits.nanoCPUs(),
+			ReadonlyRootfs: sp.ReadOnlyRootfs,
+			Tmpfs:          sp.tmpfs(),
+			PidsLimit:      int64(sp.PidsLimit),
+			Ulimits:        sp.ulimits(),
+			Runtime:        sp.Runtime,
+		},
+	}
+	for _, m := range spec.mounts {
+		cfg.HostConfig.Binds = append(cfg.HostConfig.Binds, m.bind())
+	}
+	if sp.DropAllCaps {
+		cfg.HostConfig.CapDrop = []string{"ALL"}
+	}
+	if sp.NoNewPrivileges {
+		cfg.HostConfig.SecurityOpt = append(cfg.HostConfig.SecurityOpt, "no-new-privileges")
+	}
+	if sp.SeccompProfile != "" {
+		// Unlike the CLI, the API wants the profile itself, not its path.
+		profile, err := os.ReadFile(sp.SeccompProfile)
+		if err != nil {
+			return nil, fmt.Errorf("reading seccomp profile: %w", err)
+		}
+		cfg.HostConfig.SecurityOpt = append(cfg.HostConfig.SecurityOpt, "seccomp="+string(profile))
+	}
+	return cfg, nil
+}
+
+func (e *apiEngine) run(ctx context.Context, spec containerSpec) (*containerState, error) {
+	cfg, err := e.createConfig(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := e.create(ctx, spec.name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer e.remove(id)
+
+	conn, stream, err := e.attach(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Closing the connection unblocks the reads below when ctx ends; the
+	// deferred remove then kills the container.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	err = e.do(ctx, http.MethodPost, "/containers/"+id+"/start", nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		if len(spec.stdin) > 0 {
+			conn.Write(spec.stdin)
+		}
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+	}()
+
+	err = demux(stream, spec.stdout, spec.stderr)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading container output: %w", err)
+	}
+
+	err = e.do(ctx, http.MethodPost, "/containers/"+id+"/wait", url.Values{"condition": {"not-running"}}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var inspect struct {
+		State containerState `json:"State"`
+	}
+	err = e.do(ctx, http.MethodGet, "/containers/"+id+"/json", nil, nil, &inspect)
+	if err != nil {
+		return nil, err
+	}
+	if inspect.State.Error != "" {
+		return nil, fmt.Errorf("container did not start: %s", inspect.State.Error)
+	}
+	return &inspect.State, nil
+}
+
+// create creates the container, pulling its image first if the daemon does
+// not have it yet, as "docker run" would.
+func (e *apiEngine) create(ctx context.Context, name string, cfg *createConfig) (string, error) {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	query := url.Values{"name": {name}}
+
+	err := e.do(ctx, http.MethodPost, "/containers/create", query, cfg, &created)
+	if isNotFound(err) {
+		err = e.pull(ctx, cfg.Image)
+		if err != nil {
+			return "", err
+		}
+		err = e.do(ctx, http.MethodPost, "/containers/create", query, cfg, &created)
+	}
+	if err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// pull downloads image. The daemon reports progress and failures as a stream
+// of JSON messages on a 200 response, so the stream has to be read to the end.
+func (e *apiEngine) pull(ctx context.Context, image string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL("/images/create", url.Values{"fromImage": {image}}), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return readAPIError(resp)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		err := dec.Decode(&msg)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("pulling %s: %w", image, err)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("pulling %s: %s", image, msg.Error)
+		}
+	}
+}
+
+// attach connects to the container's stdio. The daemon hijacks the HTTP
+// connection for this, which net/http's client cannot do, so the request is
+// written to a socket of our own.
+func (e *apiEngine) attach(ctx context.Context, id string) (net.Conn, *bufio.Reader, error) {
+	conn, err := e.dial(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query := url.Values{"stream": {"1"}, "stdin": {"1"}, "stdout": {"1"}, "stderr": {"1"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL("/containers/"+id+"/attach", query), nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	err = req.Write(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols && resp.StatusCode != http.StatusOK {
+		defer conn.Close()
+		return nil, nil, readAPIError(resp)
+	}
+	return conn, br, nil
+}
+
+// demux splits the multiplexed attach stream. Each frame starts with an
+// 8 byte header holding the stream (1 stdout, 2 stderr) and payload length.
+func demux(r io.Reader, stdout, stderr io.Writer) error {
+	var header [8]byte
+	for {
+		_, err := io.ReadFull(r, header[:])
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		dst := stdout
+		if header[0] == 2 {
+			dst = stderr
+		}
+
+		_, err = io.CopyN(dst, r, size)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// remove uses its own context so cleanup still happens when the run's
+// context has already expired.
+func (e *apiEngine) remove(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	e.do(ctx, http.MethodDelete, "/containers/"+id, url.Values{"force": {"1"}, "v": {"1"}}, nil, nil)
+}
+
+func (e *apiEngine) reap(ctx context.Context, olderThan time.Duration) (int, error) {
+	filters, err := json.Marshal(map[string][]string{"label": {containerLabel}})
+	if err != nil {
+		return 0, err
+	}
+
+	var containers []struct {
+		ID     string            `json:"Id"`
+		Labels map[string]string `json:"Labels"`
+	}
+	err = e.do(ctx, http.MethodGet, "/containers/json", url.Values{"all": {"1"}, "filters": {string(filters)}}, nil, &containers)
+	if err != nil {
+		return 0, fmt.Errorf("listing sandbox containers: %w", err)
+	}
+
+	labels := make(map[string]map[string]string, len(containers))
+	for _, c := range containers {
+		labels[c.ID] = c.Labels
+	}
+
+	stale := staleContainers(labels, olderThan)
+	for _, id := range stale {
+		err := e.do(ctx, http.MethodDelete, "/containers/"+id, url.Values{"force": {"1"}, "v": {"1"}}, nil, nil)
+		if err != nil && !isNotFound(err) {
+			return 0, fmt.Errorf("removing sandbox containers: %w", err)
+		}
+	}
+	return len(stale), nil
+}
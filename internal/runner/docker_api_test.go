@@ -0,0 +1,452 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDaemon answers the Engine API requests apiEngine makes on a unix
+// socket and records them. Routes listed in fail answer with that status
+// and an error message instead.
+type fakeDaemon struct {
+	mux  *http.ServeMux
+	fail map[string]int
+
+	hasImage bool
+	pullErr  string
+	stdout   string
+	stderr   string
+	state    containerState
+	// containers are listed by GET /containers/json, by ID.
+	containers map[string]map[string]string
+
+	mu       sync.Mutex
+	requests []string
+	created  createConfig
+	pulled   string
+	stdin    []byte
+	removed  []string
+}
+
+func newFakeDaemon() *fakeDaemon {
+	d := &fakeDaemon{
+		mux:      http.NewServeMux(),
+		fail:     make(map[string]int),
+		hasImage: true,
+	}
+
+	d.mux.HandleFunc("POST /v1.41/containers/create", d.create)
+	d.mux.HandleFunc("POST /v1.41/images/create", d.pull)
+	d.mux.HandleFunc("POST /v1.41/containers/{id}/attach", d.attach)
+	d.mux.HandleFunc("POST /v1.41/containers/{id}/start", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	d.mux.HandleFunc("POST /v1.41/containers/{id}/wait", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"StatusCode": %d}`, d.state.ExitCode)
+	})
+	d.mux.HandleFunc("GET /v1.41/containers/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"Id": r.PathValue("id"), "State": d.state})
+	})
+	d.mux.HandleFunc("GET /v1.41/containers/json", d.list)
+	d.mux.HandleFunc("DELETE /v1.41/containers/{id}", d.remove)
+	return d
+}
+
+func (d *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, pattern := d.mux.Handler(r)
+
+	d.mu.Lock()
+	d.requests = append(d.requests, pattern)
+	d.mu.Unlock()
+
+	if status, ok := d.fail[pattern]; ok {
+		w.WriteHeader(status)
+		fmt.Fprint(w, `{"message": "boom"}`)
+		return
+	}
+	d.mux.ServeHTTP(w, r)
+}
+
+func (d *fakeDaemon) create(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.hasImage {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message": "No such image"}`)
+		return
+	}
+	if r.URL.Query().Get("name") == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err := json.NewDecoder(r.Body).Decode(&d.created)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, `{"Id": "c0ffee"}`)
+}
+
+// pull answers like the daemon does: failures after the 200 are messages
+// in the progress stream.
+func (d *fakeDaemon) pull(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pulled = r.URL.Query().Get("fromImage")
+
+	fmt.Fprintln(w, `{"status": "Pulling from library/python"}`)
+	if d.pullErr != "" {
+		fmt.Fprintf(w, "{\"error\": %q}\n", d.pullErr)
+		return
+	}
+	fmt.Fprintln(w, `{"status": "Download complete"}`)
+	d.hasImage = true
+}
+
+// attach hijacks the connection, reads stdin until the client closes its
+// side and then sends the output as a multiplexed stream.
+func (d *fakeDaemon) attach(w http.ResponseWriter, r *http.Request) {
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprint(conn, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.multiplexed-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+
+	stdin, _ := io.ReadAll(buf)
+	d.mu.Lock()
+	d.stdin = stdin
+	d.mu.Unlock()
+
+	writeFrame(conn, 1, d.stdout)
+	writeFrame(conn, 2, d.stderr)
+}
+
+func writeFrame(w io.Writer, stream byte, payload string) {
+	if payload == "" {
+		return
+	}
+	header := [8]byte{stream}
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	w.Write(header[:])
+	io.WriteString(w, payload)
+}
+
+func (d *fakeDaemon) list(w http.ResponseWriter, r *http.Request) {
+	var filters map[string][]string
+	json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters)
+	if !slices.Contains(filters["label"], containerLabel) || r.URL.Query().Get("all") != "1" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var containers []map[string]any
+	for id, labels := range d.containers {
+		containers = append(containers, map[string]any{"Id": id, "Labels": labels})
+	}
+	json.NewEncoder(w).Encode(containers)
+}
+
+func (d *fakeDaemon) remove(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("force") != "1" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.removed = append(d.removed, r.PathValue("id"))
+	if d.containers != nil {
+		if _, ok := d.containers[r.PathValue("id")]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "No such container"}`)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newTestAPIEngine serves d on a unix socket and returns an engine that
+// talks to it.
+func newTestAPIEngine(t *testing.T, d *fakeDaemon) *apiEngine {
+	t.Helper()
+
+	// Unix socket paths are limited to about 100 bytes, which the test's
+	// own temp dir may exceed.
+	dir, err := os.MkdirTemp("", "docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socket := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(d)
+	srv.Listener = l
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	e, err := newAPIEngine("unix://" + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func testSpec(stdout, stderr io.Writer) containerSpec {
+	return containerSpec{
+		name:     "code-runner-test",
+		image:    "python:3.13-alpine",
+		command:  []string{"python", "main.py"},
+		env:      map[string]string{"GREETING": "hello"},
+		limits:   Limits{Timeout: Duration(5 * time.Second), MemoryMB: 128, CPUs: 0.5},
+		security: DefaultSecurityProfile(),
+		stdin:    []byte("some input"),
+		stdout:   stdout,
+		stderr:   stderr,
+	}
+}
+
+func TestAPIEngineRun(t *testing.T) {
+	d := newFakeDaemon()
+	d.stdout = "hello\n"
+	d.stderr = "oops\n"
+	d.state = containerState{ExitCode: 3}
+	e := newTestAPIEngine(t, d)
+
+	var stdout, stderr bytes.Buffer
+	state, err := e.run(t.Context(), testSpec(&stdout, &stderr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if state.ExitCode != 3 {
+		t.Errorf("got exit code %d, want 3", state.ExitCode)
+	}
+	if stdout.String() != "hello\n" || stderr.String() != "oops\n" {
+		t.Errorf("got stdout %q, stderr %q", stdout.String(), stderr.String())
+	}
+	if string(d.stdin) != "some input" {
+		t.Errorf("container got stdin %q", d.stdin)
+	}
+
+	for _, pattern := range []string{
+		"POST /v1.41/containers/create",
+		"POST /v1.41/containers/{id}/attach",
+		"POST /v1.41/containers/{id}/start",
+		"POST /v1.41/containers/{id}/wait",
+		"GET /v1.41/containers/{id}/json",
+	} {
+		if !slices.Contains(d.requests, pattern) {
+			t.Errorf("%s was not called", pattern)
+		}
+	}
+	if slices.Contains(d.requests, "POST /v1.41/images/create") {
+		t.Error("pulled an image the daemon already had")
+	}
+	if !slices.Equal(d.removed, []string{"c0ffee"}) {
+		t.Errorf("removed %v, want the container", d.removed)
+	}
+
+	cfg := d.created
+	if cfg.Image != "python:3.13-alpine" || !slices.Equal(cfg.Cmd, []string{"python", "main.py"}) || !slices.Equal(cfg.Env, []string{"GREETING=hello"}) {
+		t.Errorf("created %q running %q with env %q", cfg.Image, cfg.Cmd, cfg.Env)
+	}
+	hc := cfg.HostConfig
+	if hc.NetworkMode != "none" || hc.Memory != 128*1024*1024 || hc.NanoCpus != 5e8 {
+		t.Errorf("got network %q, memory %d, cpus %d", hc.NetworkMode, hc.Memory, hc.NanoCpus)
+	}
+	if !hc.ReadonlyRootfs || !slices.Equal(hc.CapDrop, []string{"ALL"}) || hc.PidsLimit != 128 || hc.Tmpfs["/app"] == "" {
+		t.Errorf("security profile not applied: %+v", hc)
+	}
+	if _, ok := cfg.Labels[containerLabel]; !ok {
+		t.Errorf("container is not labelled: %v", cfg.Labels)
+	}
+}
+
+func TestAPIEngineRunPullsMissingImage(t *testing.T) {
+	d := newFakeDaemon()
+	d.hasImage = false
+	e := newTestAPIEngine(t, d)
+
+	_, err := e.run(t.Context(), testSpec(io.Discard, io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pulled != "python:3.13-alpine" {
+		t.Errorf("pulled %q", d.pulled)
+	}
+}
+
+func TestAPIEngineRunErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    string
+		status  int
+		pullErr string
+		want    string
+		// removed tells whether the container was created, and so
+		// had to be removed again.
+		removed bool
+	}{
+		{name: "create", fail: "POST /v1.41/containers/create", status: http.StatusInternalServerError, want: "boom (status 500)"},
+		{name: "pull", fail: "POST /v1.41/images/create", status: http.StatusInternalServerError, want: "boom (status 500)"},
+		{name: "pull stream", pullErr: "manifest unknown", want: "pulling python:3.13-alpine: manifest unknown"},
+		{name: "attach", fail: "POST /v1.41/containers/{id}/attach", status: http.StatusConflict, want: "boom (status 409)", removed: true},
+		{name: "start", fail: "POST /v1.41/containers/{id}/start", status: http.StatusInternalServerError, want: "boom (status 500)", removed: true},
+		{name: "wait", fail: "POST /v1.41/containers/{id}/wait", status: http.StatusNotFound, want: "boom (status 404)", removed: true},
+		{name: "inspect", fail: "GET /v1.41/containers/{id}/json", status: http.StatusInternalServerError, want: "boom (status 500)", removed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newFakeDaemon()
+			if tt.fail != "" {
+				d.fail[tt.fail] = tt.status
+			}
+			if tt.name == "pull" || tt.pullErr != "" {
+				d.hasImage = false
+				d.pullErr = tt.pullErr
+			}
+			e := newTestAPIEngine(t, d)
+
+			_, err := e.run(t.Context(), testSpec(io.Discard, io.Discard))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want %q", err, tt.want)
+			}
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			if removed := len(d.removed) > 0; removed != tt.removed {
+				t.Errorf("got removed %v, want %v", removed, tt.removed)
+			}
+		})
+	}
+}
+
+func TestAPIEngineRunContainerError(t *testing.T) {
+	d := newFakeDaemon()
+	d.state = containerState{ExitCode: 127, Error: "exec: \"python\": executable file not found"}
+	e := newTestAPIEngine(t, d)
+
+	_, err := e.run(t.Context(), testSpec(io.Discard, io.Discard))
+	if err == nil || !strings.Contains(err.Error(), "container did not start") {
+		t.Fatalf("got error %v, want the container's start error", err)
+	}
+}
+
+func TestAPIEngineReap(t *testing.T) {
+	now := time.Now()
+	d := newFakeDaemon()
+	d.containers = map[string]map[string]string{
+		"running": {
+			containerLabel: "",
+			createdLabel:   strconv.FormatInt(now.Add(-time.Minute).Unix(), 10),
+			timeoutLabel:   "10",
+		},
+		"orphan": {
+			containerLabel: "",
+			createdLabel:   strconv.FormatInt(now.Add(-time.Hour).Unix(), 10),
+			timeoutLabel:   "10",
+		},
+		"unlabelled": {
+			containerLabel: "",
+		},
+	}
+	e := newTestAPIEngine(t, d)
+
+	n, err := e.reap(t.Context(), 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if n != 2 {
+		t.Errorf("reaped %d containers, want 2", n)
+	}
+	slices.Sort(d.removed)
+	if !slices.Equal(d.removed, []string{"orphan", "unlabelled"}) {
+		t.Errorf("removed %v", d.removed)
+	}
+}
+
+func TestAPIEngineReapErrors(t *testing.T) {
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	t.Run("list", func(t *testing.T) {
+		d := newFakeDaemon()
+		d.fail["GET /v1.41/containers/json"] = http.StatusInternalServerError
+		e := newTestAPIEngine(t, d)
+
+		_, err := e.reap(t.Context(), 5*time.Minute)
+		if err == nil || !strings.Contains(err.Error(), "listing sandbox containers") {
+			t.Fatalf("got error %v", err)
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		d := newFakeDaemon()
+		d.containers = map[string]map[string]string{"orphan": {containerLabel: "", createdLabel: old}}
+		d.fail["DELETE /v1.41/containers/{id}"] = http.StatusInternalServerError
+		e := newTestAPIEngine(t, d)
+
+		_, err := e.reap(t.Context(), 5*time.Minute)
+		if err == nil || !strings.Contains(err.Error(), "removing sandbox containers") {
+			t.Fatalf("got error %v", err)
+		}
+	})
+
+	t.Run("already gone", func(t *testing.T) {
+		d := newFakeDaemon()
+		d.containers = map[string]map[string]string{"orphan": {containerLabel: "", createdLabel: old}}
+		e := newTestAPIEngine(t, d)
+		// The container disappears between listing and removal.
+		d.mux.HandleFunc("DELETE /v1.41/containers/orphan", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "No such container"}`)
+		})
+
+		n, err := e.reap(t.Context(), 5*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("reaped %d containers, want 1", n)
+		}
+	})
+}
+
+func TestNewDockerRunnerRejectsInteractiveAPI(t *testing.T) {
+	_, err := NewDockerRunner(NewRegistry(), DockerOptions{
+		Backend:     BackendAPI,
+		Security:    DefaultSecurityProfile(),
+		Interactive: true,
+	})
+	if err == nil || !strings.Contains(err.Error(), "terminals and REPL sessions") {
+		t.Fatalf("got error %v, want the api backend refused for terminals and REPL sessions", err)
+	}
+}
@@ -0,0 +1,403 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cliEngine runs containers by shelling out to the docker binary, or to
+// podman, whose CLI accepts the same commands and flags. The few places
+// where the two differ check podman.
+type cliEngine struct {
+	binary string
+	podman bool
+}
+
+func newCLIEngine(engine string) (*cliEngine, error) {
+	switch engine {
+	case EngineDocker, "":
+		return &cliEngine{binary: "docker"}, nil
+	case EnginePodman:
+		e := &cliEngine{binary: "podman", podman: true}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		return e, e.checkPodman(ctx)
+	}
+	return nil, fmt.Errorf("unknown container engine %q", engine)
+}
+
+func (e *cliEngine) command(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, e.binary, args...)
+}
+
+// checkPodman fails early when Podman cannot enforce the memory, CPU and
+// process limits every container gets. Rootless Podman can only do so when
+// the user has the cgroup v2 controllers delegated; otherwise every single
+// run would fail.
+func (e *cliEngine) checkPodman(ctx context.Context) error {
+	out, err := e.command(ctx, "info", "--format", "json").Output()
+	if err != nil {
+		return fmt.Errorf("podman info: %w", err)
+	}
+
+	var info struct {
+		Host struct {
+			CgroupVersion     string   `json:"cgroupVersion"`
+			CgroupControllers []string `json:"cgroupControllers"`
+		} `json:"host"`
+	}
+	err = json.Unmarshal(out, &info)
+	if err != nil {
+		return fmt.Errorf("podman info: %w", err)
+	}
+
+	if info.Host.CgroupVersion != "v2" {
+		return errors.New("podman needs cgroup v2 to limit sandbox resources")
+	}
+	for _, controller := range []string{"memory", "cpu", "pids"} {
+		if !slices.Contains(info.Host.CgroupControllers, controller) {
+			return fmt.Errorf("podman cannot limit sandboxes: the %s cgroup controller is not delegated to this user", controller)
+		}
+	}
+	return nil
+}
+
+func (e *cliEngine) run(ctx context.Context, spec containerSpec) (*containerState, error) {
+	args := append([]string{"run"}, e.containerArgs(spec)...)
+	for _, m := range spec.mounts {
+		args = append(args, "-v", m.bind())
+	}
+	if spec.tty != nil {
+		args = append(args, "-i", "-t")
+	} else if len(spec.stdin) > 0 || spec.input != nil {
+		args = append(args, "-i")
+	}
+	for _, kv := range envList(spec.env) {
+		args = append(args, "-e", kv)
+	}
+	args = append(args, spec.image)
+	args = append(args, spec.command...)
+
+	cmd := e.command(ctx, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+	if spec.tty != nil {
+		// The terminal has to be the CLI's controlling one, so that it
+		// gets SIGWINCH and passes resizes on to the container.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	}
+	// Killing the docker CLI alone leaves the container running, so take
+	// the container down first and let the CLI exit on its own.
+	cmd.Cancel = func() error {
+		e.remove(spec.name)
+		return cmd.Process.Kill()
+	}
+	cmd.WaitDelay = 5 * time.Second
+	if len(spec.stdin) > 0 {
+		cmd.Stdin = bytes.NewReader(spec.stdin)
+	}
+	if spec.input != nil {
+		cmd.Stdin = spec.input
+	}
+	cmd.Stdout = spec.stdout
+	cmd.Stderr = spec.stderr
+	if spec.tty != nil {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = spec.tty, spec.tty, spec.tty
+	}
+
+	err := cmd.Run()
+	defer e.remove(spec.name)
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+
+	state, err := e.inspect(spec.name)
+	if err != nil {
+		return nil, fmt.Errorf("container did not start: %w", err)
+	}
+	if state.Error != "" {
+		return nil, fmt.Errorf("container did not start: %s", state.Error)
+	}
+	return state, nil
+}
+
+// containerArgs returns the "run" flags that name, label and confine the
+// container.
+func (e *cliEngine) containerArgs(spec containerSpec) []string {
+	args := []string{
+		"--name", spec.name,
+		"--network", spec.networkMode(),
+		"--memory", spec.limits.dockerMemory(),
+		"--cpus", spec.limits.dockerCPUs(),
+		"-w", "/app",
+	}
+	labels := spec.labels()
+	for _, key := range slices.Sorted(maps.Keys(labels)) {
+		if value := labels[key]; value == "" {
+			args = append(args, "--label", key)
+		} else {
+			args = append(args, "--label", key+"="+value)
+		}
+	}
+	args = append(args, spec.security.dockerArgs()...)
+	if e.podman && spec.security.ReadOnlyRootfs {
+		// Podman adds unlimited tmpfs mounts on /run and /var/tmp to
+		// read-only containers unless told otherwise.
+		args = append(args, "--read-only-tmpfs=false")
+	}
+	return args
+}
+
+func (e *cliEngine) inspect(name string) (*containerState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	out, err := e.command(ctx, "inspect", "--format", "{{json .State}}", name).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var state containerState
+	err = json.Unmarshal(out, &state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// remove uses its own context so cleanup still happens when the run's
+// context has already expired.
+func (e *cliEngine) remove(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	e.command(ctx, "rm", "-f", name).Run()
+}
+
+func (e *cliEngine) reap(ctx context.Context, olderThan time.Duration) (int, error) {
+	labels, err := e.list(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("listing sandbox containers: %w", err)
+	}
+
+	stale := staleContainers(labels, olderThan)
+	if len(stale) == 0 {
+		return 0, nil
+	}
+
+	err = e.command(ctx, append([]string{"rm", "-f"}, stale...)...).Run()
+	if err != nil {
+		return 0, fmt.Errorf("removing sandbox containers: %w", err)
+	}
+	return len(stale), nil
+}
+
+// list returns the labels of every sandbox container, keyed by ID.
+func (e *cliEngine) list(ctx context.Context) (map[string]map[string]string, error) {
+	args := []string{"ps", "-a", "--filter", "label=" + containerLabel}
+
+	labels := make(map[string]map[string]string)
+
+	if e.podman {
+		// Podman's template sees labels as a map, so JSON is simpler.
+		out, err := e.command(ctx, append(args, "--format", "json")...).Output()
+		if err != nil {
+			return nil, err
+		}
+		var containers []struct {
+			ID     string            `json:"Id"`
+			Labels map[string]string `json:"Labels"`
+		}
+		err = json.Unmarshal(out, &containers)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range containers {
+			labels[c.ID] = c.Labels
+		}
+		return labels, nil
+	}
+
+	out, err := e.command(ctx, append(args, "--format", "{{.ID}}\t{{.Labels}}")...).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	// Labels come as "key=value,key=value". Values of image labels may
+	// contain commas too, but ours never do.
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		id, list, _ := strings.Cut(line, "\t")
+		if id == "" {
+			continue
+		}
+		labels[id] = make(map[string]string)
+		for _, label := range strings.Split(list, ",") {
+			key, value, _ := strings.Cut(label, "=")
+			labels[id][key] = value
+		}
+	}
+	return labels, nil
+}
+
+// createWarm starts a container for spec that idles until runWarm gives it
+// a command, and pauses it so it uses no CPU in the meantime. Bind mounts
+// cannot be added to a running container, so /src and the stats file live
+// on tmpfs mounts of their own.
+func (e *cliEngine) createWarm(ctx context.Context, spec containerSpec) error {
+	srcSize := spec.security.WorkDirSizeMB
+	if srcSize == 0 {
+		srcSize = 64
+	}
+
+	args := append([]string{"run", "-d"}, e.containerArgs(spec)...)
+	args = append(args,
+		"--tmpfs", fmt.Sprintf("%s:rw,nosuid,nodev,size=%dm,mode=1777", srcMountPath, srcSize),
+		"--tmpfs", fmt.Sprintf("%s:rw,nosuid,nodev,size=1m,mode=1777", filepath.Dir(statsMountPath)),
+		spec.image, "sleep", "3600",
+	)
+
+	out, err := e.command(ctx, args...).CombinedOutput()
+	if err == nil {
+		out, err = e.command(ctx, "pause", spec.name).CombinedOutput()
+	}
+	if err != nil {
+		e.remove(spec.name)
+		return fmt.Errorf("creating warm container: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// runWarm runs spec's command in the warm container name and removes the
+// container afterwards. Directory mounts of spec are copied into the
+// container before the command runs, and file mounts are copied back out
+// once it exits.
+func (e *cliEngine) runWarm(ctx context.Context, name string, spec containerSpec) (*containerState, error) {
+	defer e.remove(name)
+
+	out, err := e.command(ctx, "unpause", name).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("unpausing warm container: %w: %s", err, bytes.TrimSpace(out))
+	}
+
+	for _, m := range spec.mounts {
+		info, err := os.Stat(m.source)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			err = e.copyIn(ctx, name, m.source, m.target)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	args := []string{"exec"}
+	if len(spec.stdin) > 0 {
+		args = append(args, "-i")
+	}
+	for _, kv := range envList(spec.env) {
+		args = append(args, "-e", kv)
+	}
+	args = append(args, "-w", "/app", name)
+	args = append(args, spec.command...)
+
+	cmd := e.command(ctx, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+	cmd.Cancel = func() error {
+		e.remove(name)
+		return cmd.Process.Kill()
+	}
+	cmd.WaitDelay = 5 * time.Second
+	if len(spec.stdin) > 0 {
+		cmd.Stdin = bytes.NewReader(spec.stdin)
+	}
+	cmd.Stdout = spec.stdout
+	cmd.Stderr = spec.stderr
+	if spec.tty != nil {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = spec.tty, spec.tty, spec.tty
+	}
+
+	startedAt := time.Now()
+	err = cmd.Run()
+	finishedAt := time.Now()
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+
+	for _, m := range spec.mounts {
+		info, err := os.Stat(m.source)
+		if err == nil && !info.IsDir() {
+			e.copyOut(ctx, name, m.target, m.source)
+		}
+	}
+
+	state := &containerState{
+		ExitCode:   cmd.ProcessState.ExitCode(),
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+	}
+	// The OOM killer may have taken the idle process, and with it the
+	// whole container, instead of the program.
+	if inspected, err := e.inspect(name); err == nil {
+		state.OOMKilled = inspected.OOMKilled
+	}
+	return state, nil
+}
+
+// copyIn copies the contents of dir to target inside the container.
+func (e *cliEngine) copyIn(ctx context.Context, name, dir, target string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := tw.AddFS(os.DirFS(dir))
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+
+	cmd := e.command(ctx, "exec", "-i", name, "tar", "-x", "-C", target)
+	cmd.Stdin = pr
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("copying %s into container: %w: %s", dir, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// copyOut overwrites file with the contents of target inside the container.
+func (e *cliEngine) copyOut(ctx context.Context, name, target, file string) error {
+	out, err := e.command(ctx, "exec", name, "cat", target).Output()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, out, 0o666)
+}
@@ -1,99 +1,679 @@
 package runner
 
 import (
-	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"syscall"
+	"slices"
+	"strconv"
+	"time"
 )
 
-type LanguageConfig struct {
-	Image    string
-	FileName string
-	Command  []string
+const (
+	BackendCLI = "cli"
+	BackendAPI = "api"
+
+	EngineDocker = "docker"
+	EnginePodman = "podman"
+)
+
+type DockerOptions struct {
+	// Engine is EngineDocker or EnginePodman. Podman may run rootless, as
+	// long as the memory, cpu and pids cgroup controllers are delegated.
+	// With BackendAPI, Host should then point at Podman's Docker
+	// compatible socket.
+	Engine string
+	// Backend is BackendCLI to shell out to the docker binary, or
+	// BackendAPI to talk to the Engine API on Host directly.
+	Backend  string
+	Host     string
+	Security SecurityProfile
+	// WarmPool is the number of paused containers kept ready for the run
+	// phase of each language. Zero disables the pool. It needs BackendCLI.
+	WarmPool int
+	// Interactive makes the runner host terminals and REPL sessions, which
+	// attach a pseudo-terminal or an input pipe. It needs BackendCLI.
+	Interactive bool
+	// Deps enables dependency manifests when its CacheDir is set.
+	Deps DepsOptions
+	// Output bounds and cleans up program output. Zero fields take their
+	// DefaultOutputOptions value.
+	Output OutputOptions
 }
 
+// DockerRunner executes each phase of a run in a fresh container, created
+// through Docker or Podman.
 type DockerRunner struct {
-	config map[string]LanguageConfig
-}
-
-func NewDockerRunner() *DockerRunner {
-	return &DockerRunner{
-		config: map[string]LanguageConfig{
-			"python": {
-				Image:    "python:alpine",
-				FileName: "main.py",
-				Command:  []string{"python", "/app/main.py"},
-			},
-			"ruby": {
-				Image:    "ruby:alpine",
-				FileName: "main.rb",
-				Command:  []string{"ruby", "/app/main.rb"},
-			},
-			"javascript": {
-				Image:    "node:alpine",
-				FileName: "index.js",
-				Command:  []string{"node", "index.js"},
-			},
-		},
-	}
-}
-
-func (dr *DockerRunner) Run(ctx context.Context, code, language string) (*ExecuteResult, error) {
-	config, ok := dr.config[language]
-	if !ok {
-		return nil, fmt.Errorf("unsupported language: %s", language)
+	sandbox
+}
+
+// sandbox runs the compile and run phases of a request, each in a fresh
+// isolated environment created by engine.
+type sandbox struct {
+	languages *Registry
+	security  SecurityProfile
+	engine    containerEngine
+	warm      *warmPool
+	deps      *depsCache
+	output    OutputOptions
+}
+
+func NewDockerRunner(languages *Registry, opts DockerOptions) (*DockerRunner, error) {
+	err := opts.Security.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = opts.Output.Validate()
+	if err != nil {
+		return nil, err
 	}
+
+	dr := &DockerRunner{sandbox{
+		languages: languages,
+		security:  opts.Security,
+		output:    opts.Output.withDefaults(),
+	}}
+
+	switch opts.Backend {
+	case BackendCLI, "":
+		cli, err := newCLIEngine(opts.Engine)
+		if err != nil {
+			return nil, err
+		}
+		dr.engine = cli
+		if opts.WarmPool > 0 {
+			dr.warm = newWarmPool(cli, languages, opts.Security, opts.WarmPool)
+		}
+	case BackendAPI:
+		if opts.Engine != EngineDocker && opts.Engine != EnginePodman && opts.Engine != "" {
+			return nil, fmt.Errorf("unknown container engine %q", opts.Engine)
+		}
+		if opts.WarmPool > 0 {
+			return nil, errors.New("the warm container pool needs the cli docker backend")
+		}
+		if opts.Interactive {
+			return nil, errors.New("terminals and REPL sessions need the cli docker backend")
+		}
+		dr.engine, err = newAPIEngine(opts.Host)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown docker backend %q", opts.Backend)
+	}
+
+	if opts.Deps.CacheDir != "" {
+		dr.deps, err = newDepsCache(opts.Deps)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dr, nil
+}
+
+// WarmPoolStats returns a snapshot of the warm pool's counters, suitable for
+// expvar. It is all zeros when the pool is disabled.
+func (dr *DockerRunner) WarmPoolStats() WarmPoolStats {
+	if dr.warm == nil {
+		return WarmPoolStats{}
+	}
+	return dr.warm.stats()
+}
+
+// phase describes a single container invocation. Compiled languages run a
+// compile phase and a run phase against the same /app directory.
+type phase struct {
+	image   string
+	command []string
+	limits  Limits
+	stdin   []byte
+	env     map[string]string
+	// save copies the work dir back to the host after the phase, so the
+	// next phase can use what it produced.
+	save bool
+	// network is "none" unless the phase installs dependencies.
+	network string
+	mounts  []mount
+	// emit, when set, receives the output as it is written.
+	emit func(OutputEvent)
+	// tty, when set, is the terminal of an interactive phase. It replaces
+	// stdin and both output streams.
+	tty *os.File
+	// input and output, when set, are pipes a long-running program reads
+	// requests from and writes both output streams to.
+	input  *os.File
+	output *os.File
+}
+
+func (s *sandbox) Run(ctx context.Context, req ExecuteRequest) (*ExecuteResult, error) {
+	return s.RunStream(ctx, req, nil)
+}
+
+// RunStream runs req like Run and passes the output of its run phase to
+// emit while it is produced. A nil emit streams nothing.
+func (s *sandbox) RunStream(ctx context.Context, req ExecuteRequest, emit func(OutputEvent)) (*ExecuteResult, error) {
 	tmpDir, err := os.MkdirTemp("", "runner-*")
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create temp dir: %w", err)
 	}
 	defer os.RemoveAll(tmpDir)
 
-	hostFilePath := filepath.Join(tmpDir, config.FileName)
-	if err := os.WriteFile(hostFilePath, []byte(code), 0o644); err != nil {
-		return nil, fmt.Errorf("failed to write code to file: %w", err)
+	ws, result, err := s.prepare(ctx, tmpDir, req)
+	if err != nil || result != nil {
+		return result, err
+	}
+
+	if req.TestCode != "" {
+		result, err := s.runTests(ctx, tmpDir, ws, req.Limits)
+		if err != nil {
+			return nil, err
+		}
+		result.Install = ws.install
+		return result, nil
+	}
+
+	p := ws.runPhase(req)
+	p.emit = emit
+	result, err = s.runPhase(ctx, tmpDir, p)
+	if err != nil {
+		return nil, err
+	}
+	result.Compile = ws.compile
+	result.Install = ws.install
+	return result, nil
+}
+
+// RunBatch prepares req once and then runs its run phase with each input
+// against the same work dir. Run phases never save it, so every one of them
+// starts from what the compilation left.
+func (s *sandbox) RunBatch(ctx context.Context, req ExecuteRequest, inputs []RunInput) ([]*ExecuteResult, error) {
+	tmpDir, err := os.MkdirTemp("", "runner-*")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ws, result, err := s.prepare(ctx, tmpDir, req)
+	if err != nil {
+		return nil, err
+	}
+	if result != nil {
+		return []*ExecuteResult{result}, nil
+	}
+
+	results := make([]*ExecuteResult, 0, len(inputs))
+	for _, in := range inputs {
+		run := req
+		run.Stdin = in.Stdin
+		run.Limits = in.Limits
+
+		result, err := s.runPhase(ctx, tmpDir, ws.runPhase(run))
+		if err != nil {
+			return nil, err
+		}
+		result.Compile = ws.compile
+		result.Install = ws.install
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// workspace is a request's work dir, ready for its run phase.
+type workspace struct {
+	lang       Language
+	files      []File
+	entryPoint string
+	// env and mounts make the installed dependencies available.
+	env     map[string]string
+	mounts  []mount
+	install *ExecuteResult
+	compile *ExecuteResult
+}
+
+// prepare writes the files of req to tmpDir, installs their dependencies
+// and, unless req is a test run, compiles them. When the run ends before
+// its run phase, because the install or compilation failed, the result to
+// report is returned instead of a workspace.
+func (s *sandbox) prepare(ctx context.Context, tmpDir string, req ExecuteRequest) (*workspace, *ExecuteResult, error) {
+	lang, ok := s.languages.Get(req.Language)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported language: %s", req.Language)
+	}
+	lang, ok = lang.WithVersion(req.Version)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported version %s of %s", req.Version, req.Language)
+	}
+
+	files, entryPoint, err := lang.sourceFiles(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The sandbox user is usually not the one running this process, so the
+	// source dir must be writable for everybody regardless of umask.
+	appDir := filepath.Join(tmpDir, "app")
+	if err := os.Mkdir(appDir, 0o777); err != nil {
+		return nil, nil, fmt.Errorf("failed to create app dir: %w", err)
+	}
+	if err := writeFiles(appDir, files); err != nil {
+		return nil, nil, fmt.Errorf("failed to write code to files: %w", err)
+	}
+
+	ws := &workspace{lang: lang, files: files, entryPoint: entryPoint}
+
+	depsDir, install, err := s.dependencies(ctx, tmpDir, lang, files, req.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	ws.install = install
+	if install != nil && install.Status != StatusOK {
+		result := &ExecuteResult{
+			Status:   StatusDependencyError,
+			ExitCode: install.ExitCode,
+			Install:  install,
+		}
+		if install.Status == StatusInternalError {
+			result.Status = StatusInternalError
+			result.Error = install.Error
+		}
+		return nil, result, nil
+	}
+	if depsDir != "" {
+		ws.env = lang.Dependencies.Env
+		ws.mounts = []mount{{source: depsDir, target: depsMountPath, readOnly: true}}
+	}
+
+	if !lang.Compiled() || req.TestCode != "" {
+		return ws, nil, nil
+	}
+
+	compile, err := s.runPhase(ctx, tmpDir, phase{
+		image:   lang.Image,
+		command: lang.expandCommand(lang.CompileCommand, files, entryPoint),
+		limits:  lang.CompileLimits,
+		env:     ws.env,
+		save:    true,
+		mounts:  ws.mounts,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if compile.Status == StatusRuntimeError {
+		compile.Status = StatusCompileError
+	}
+	ws.compile = compile
+	if compile.Status != StatusOK {
+		result := &ExecuteResult{
+			Status:   StatusCompileError,
+			ExitCode: compile.ExitCode,
+			Compile:  compile,
+			Install:  install,
+		}
+		if compile.Status == StatusInternalError {
+			result.Status = StatusInternalError
+			result.Error = compile.Error
+		}
+		return nil, result, nil
+	}
+	return ws, nil, nil
+}
+
+// runPhase returns the phase that runs the program of req.
+func (ws *workspace) runPhase(req ExecuteRequest) phase {
+	return phase{
+		image:   ws.lang.Image,
+		command: append(ws.lang.expandCommand(ws.lang.RunCommand, ws.files, ws.entryPoint), req.Args...),
+		limits:  req.Limits.withDefaults(ws.lang.RunLimits),
+		stdin:   req.Stdin,
+		env:     mergeEnv(maps.Clone(req.Env), ws.env),
+		mounts:  ws.mounts,
+	}
+}
+
+// runTests runs the language's test framework in place of the compile and
+// run phases and reads back the report it wrote. Zero fields of limits take
+// the framework's own.
+func (s *sandbox) runTests(ctx context.Context, tmpDir string, ws *workspace, limits Limits) (*ExecuteResult, error) {
+	reportFile := filepath.Join(tmpDir, "report")
+	if err := os.WriteFile(reportFile, nil, 0o666); err != nil {
+		return nil, fmt.Errorf("failed to create report file: %w", err)
+	}
+	if err := os.Chmod(reportFile, 0o666); err != nil {
+		return nil, fmt.Errorf("failed to create report file: %w", err)
+	}
+
+	test := ws.lang.Test
+	image := test.Image
+	if image == "" {
+		image = ws.lang.Image
+	}
+	result, err := s.runPhase(ctx, tmpDir, phase{
+		image:   image,
+		command: ws.lang.expandCommand(test.Command, ws.files, ws.entryPoint),
+		limits:  limits.withDefaults(test.Limits),
+		env:     ws.env,
+		mounts:  append(slices.Clone(ws.mounts), mount{source: reportFile, target: reportMountPath}),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(reportFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
 	}
-	dockerArgs := []string{
-		"run",
-		"--rm",
-		"--network", "none",
-		"--memory", "128m",
-		"--cpus", "0.5",
-		"-v", fmt.Sprintf("%s:/app/%s", hostFilePath, config.FileName),
-		"-w", "/app",
-		config.Image,
+	defer f.Close()
+	report, err := io.ReadAll(io.LimitReader(f, maxReportBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
 	}
-	dockerArgs = append(dockerArgs, config.Command...)
+	result.Report = string(report)
+	return result, nil
+}
+
+// runPhase starts one container with tmpDir's app directory and stats file
+// mounted and reports how it ended. Exceeding p.limits.Timeout is reported as a
+// timeout result, while cancellation of ctx itself is returned as an error.
+func (s *sandbox) runPhase(ctx context.Context, tmpDir string, p phase) (*ExecuteResult, error) {
+	phaseCtx, cancel := context.WithTimeout(ctx, time.Duration(p.limits.Timeout))
+	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "docker", dockerArgs...)
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
+	// Only this one file is mounted writable, so a program cannot use the
+	// stats mount to fill the host's disk beyond the fsize limit.
+	statsFile := filepath.Join(tmpDir, "stats")
+	if err := os.WriteFile(statsFile, nil, 0o666); err != nil {
+		return nil, fmt.Errorf("failed to create stats file: %w", err)
+	}
+	if err := os.Chmod(statsFile, 0o666); err != nil {
+		return nil, fmt.Errorf("failed to create stats file: %w", err)
 	}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	name, err := containerName()
+	if err != nil {
+		return nil, err
+	}
 
-	err = cmd.Run()
+	env := maps.Clone(p.env)
+	if s.security.User != "" {
+		// Non-root users usually have no writable home, and compilers
+		// such as go need one for their caches.
+		env = mergeEnv(env, map[string]string{"HOME": "/tmp"})
+	}
+
+	// Output past the limits kills the phase rather than being read and
+	// dropped until it times out.
+	runCtx, kill := context.WithCancelCause(phaseCtx)
+	defer kill(nil)
+	exceeded := func() { kill(errOutputLimit) }
+
+	stdout := &limitedBuffer{max: s.output.StdoutBytes, exceeded: exceeded}
+	stderr := &limitedBuffer{max: s.output.StderrBytes, exceeded: exceeded}
+	var stdoutW, stderrW io.Writer = stdout, stderr
+	switch {
+	case p.output != nil:
+		stdoutW, stderrW = p.output, p.output
+	case p.emit != nil:
+		stdoutW = &streamWriter{buf: stdout, stream: StreamStdout, emit: p.emit, sanitizer: s.output.sanitizer()}
+		stderrW = &streamWriter{buf: stderr, stream: StreamStderr, emit: p.emit, sanitizer: s.output.sanitizer()}
+	}
+
+	spec := containerSpec{
+		name:     name,
+		image:    p.image,
+		command:  wrap(p.command, p.save),
+		env:      env,
+		limits:   p.limits,
+		security: s.security,
+		network:  p.network,
+		mounts: append([]mount{
+			{source: filepath.Join(tmpDir, "app"), target: srcMountPath, readOnly: !p.save},
+			{source: statsFile, target: statsMountPath},
+		}, p.mounts...),
+		stdin:  p.stdin,
+		stdout: stdoutW,
+		stderr: stderrW,
+		tty:    p.tty,
+		input:  p.input,
+	}
+
+	var exit *containerState
+	start := time.Now()
+	if name, ok := s.warmContainer(p); ok {
+		exit, err = s.warm.engine.runWarm(runCtx, name, spec)
+	} else {
+		exit, err = s.engine.run(runCtx, spec)
+	}
+	elapsed := time.Since(start)
 
 	result := ExecuteResult{
-		Output: stdout.String(),
-		Error:  stderr.String(),
+		Output:    s.output.sanitize(stdout.String()),
+		Error:     s.output.sanitize(stderr.String()),
+		Truncated: stdout.truncated || stderr.truncated,
+		Limits:    &p.limits,
 	}
 
-	if ctx.Err() == context.DeadlineExceeded {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if errors.Is(context.Cause(runCtx), errOutputLimit) {
+		result.Status = StatusOutputLimitExceeded
+		result.ExitCode = -1
+		result.WallTimeMS = elapsed.Milliseconds()
+		return &result, nil
+	}
+	if phaseCtx.Err() == context.DeadlineExceeded {
+		result.Status = StatusTimeout
+		result.ExitCode = -1
+		result.WallTimeMS = elapsed.Milliseconds()
 		result.Error = "Execution timed out"
 		return &result, nil
 	}
-
 	if err != nil {
+		// The container never ran the program, so whatever the engine
+		// printed on stderr describes a sandbox failure, not a user error.
+		result.Status = StatusInternalError
+		result.ExitCode = -1
 		if result.Error == "" {
 			result.Error = fmt.Sprintf("Execution failed: %v", err)
 		}
+		return &result, nil
+	}
+
+	stats := readStats(statsFile)
+	if stats.setupFailed {
+		result.Status = StatusInternalError
+		result.ExitCode = -1
+		result.Error = "Execution failed: could not prepare the work directory"
+		return &result, nil
+	}
+
+	result.ExitCode = exit.ExitCode
+	result.WallTimeMS = exit.FinishedAt.Sub(exit.StartedAt).Milliseconds()
+	result.CPUTimeMS = stats.cpuUsec / 1000
+	result.PeakMemoryBytes = stats.peakMemory
+
+	switch {
+	case exit.OOMKilled || stats.oomKills > 0:
+		result.Status = StatusOOMKilled
+	case exit.ExitCode != 0:
+		result.Status = StatusRuntimeError
+	default:
+		result.Status = StatusOK
 	}
 	return &result, nil
 }
+
+// warmContainer takes a warm container for p, if there is one. Phases that
+// save their work dir, mount dependencies, need the network, a terminal or
+// an input pipe always get a fresh container, as warm ones only have their
+// files copied in and are created without a network or terminal.
+func (s *sandbox) warmContainer(p phase) (string, bool) {
+	if s.warm == nil || p.save || p.network != "" || len(p.mounts) > 0 || p.tty != nil || p.input != nil {
+		return "", false
+	}
+	return s.warm.get(warmKey{image: p.image, memoryMB: p.limits.MemoryMB, cpus: p.limits.CPUs})
+}
+
+// Close removes the idle containers of the warm pool, if there is one.
+func (s *sandbox) Close() {
+	if s.warm != nil {
+		s.warm.close()
+	}
+}
+
+// Reap force-removes sandbox containers that were created more than
+// olderThan ago. Those are left behind when the process crashes between
+// starting a sandbox and cleaning it up. Sandboxes younger than olderThan may
+// belong to another process sharing the host and are left alone.
+func (s *sandbox) Reap(ctx context.Context, olderThan time.Duration) (int, error) {
+	return s.engine.reap(ctx, olderThan)
+}
+
+// containerEngine creates the isolated environment for one phase. Every
+// implementation runs the container to completion, streams its output into
+// the spec's writers and always removes it again before returning.
+type containerEngine interface {
+	// run returns an error if the container could not be created or
+	// started, or if ctx ended first. A program exiting with a non-zero
+	// code is not an error.
+	run(ctx context.Context, spec containerSpec) (*containerState, error)
+	reap(ctx context.Context, olderThan time.Duration) (int, error)
+}
+
+type mount struct {
+	source   string
+	target   string
+	readOnly bool
+}
+
+func (m mount) bind() string {
+	if m.readOnly {
+		return m.source + ":" + m.target + ":ro"
+	}
+	return m.source + ":" + m.target
+}
+
+type containerSpec struct {
+	name     string
+	image    string
+	command  []string
+	env      map[string]string
+	limits   Limits
+	security SecurityProfile
+	network  string
+	mounts   []mount
+	stdin    []byte
+	stdout   io.Writer
+	stderr   io.Writer
+	tty      *os.File
+	input    *os.File
+	// warm marks a container that is created ahead of time and may wait
+	// up to warmMaxAge for its run.
+	warm bool
+}
+
+// networkMode is the container network, none unless the spec names one.
+func (spec containerSpec) networkMode() string {
+	if spec.network == "" {
+		return "none"
+	}
+	return spec.network
+}
+
+// labels marks the container as ours, so Reap can find it after a crash,
+// and records how long it may legitimately run.
+func (spec containerSpec) labels() map[string]string {
+	labels := map[string]string{
+		containerLabel: "",
+		createdLabel:   strconv.FormatInt(time.Now().Unix(), 10),
+		timeoutLabel:   strconv.FormatInt(int64(time.Duration(spec.limits.Timeout).Seconds()), 10),
+	}
+	if spec.warm {
+		labels[warmLabel] = ""
+	}
+	return labels
+}
+
+// containerState is the part of Docker's container state we care about. The
+// JSON field names match both "docker inspect" and the Engine API.
+type containerState struct {
+	ExitCode   int       `json:"ExitCode"`
+	OOMKilled  bool      `json:"OOMKilled"`
+	Error      string    `json:"Error"`
+	StartedAt  time.Time `json:"StartedAt"`
+	FinishedAt time.Time `json:"FinishedAt"`
+}
+
+const (
+	containerLabel = "code-runner.sandbox"
+	createdLabel   = "code-runner.created"
+	timeoutLabel   = "code-runner.timeout"
+	warmLabel      = "code-runner.warm"
+)
+
+func containerName() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "runner-" + hex.EncodeToString(b), nil
+}
+
+// staleContainers returns the IDs, keys of labels, of containers created
+// more than olderThan ago. Containers get their own timeout on top, since
+// interactive and REPL sessions run far longer than olderThan, and warm
+// containers get warmMaxAge, as they may legitimately wait that long before
+// their run starts. Containers with a missing or broken created label are
+// treated as stale.
+func staleContainers(labels map[string]map[string]string, olderThan time.Duration) []string {
+	now := time.Now()
+
+	var stale []string
+	for id, l := range labels {
+		grace := olderThan
+		if timeout, err := strconv.ParseInt(l[timeoutLabel], 10, 64); err == nil {
+			grace += time.Duration(timeout) * time.Second
+		}
+		if _, ok := l[warmLabel]; ok {
+			grace += warmMaxAge
+		}
+		createdAt, err := strconv.ParseInt(l[createdLabel], 10, 64)
+		if err != nil || createdAt < now.Add(-grace).Unix() {
+			stale = append(stale, id)
+		}
+	}
+	slices.Sort(stale)
+	return stale
+}
+
+// envList turns env into sorted "KEY=VALUE" pairs. The value is always given
+// explicitly so docker never falls back to copying a variable from the host.
+func envList(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	list := make([]string, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, key+"="+env[key])
+	}
+	return list
+}
+
+// mergeEnv returns env with extra added on top.
+func mergeEnv(env, extra map[string]string) map[string]string {
+	if env == nil {
+		env = make(map[string]string, len(extra))
+	}
+	maps.Copy(env, extra)
+	return env
+}
@@ -0,0 +1,167 @@
+package runner
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/VJ-2303/code-runner/internal/validator"
+)
+
+// The total stays well below the API's 1MB request body limit, which also
+// has to fit stdin and the JSON encoding.
+const (
+	MaxFiles          = 64
+	MaxFilePathBytes  = 255
+	MaxFileBytes      = 256 * 1024
+	MaxTotalFileBytes = 512 * 1024
+)
+
+// File is one file of a multi-file run. Path is relative to /app and always
+// uses forward slashes.
+type File struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// ValidateFiles checks a set of files and the entry point among them. Paths
+// must stay inside /app, so absolute paths, ".." and unclean paths such as
+// "a//b" are rejected outright rather than cleaned up. Paths end up in the
+// compiler's arguments, so no segment may start with "-" either.
+func ValidateFiles(v *validator.Validator, files []File, entryPoint string) {
+	v.Check(len(files) <= MaxFiles, "files", "must not contain more than 64 files")
+
+	total := 0
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		total += len(f.Content)
+
+		v.Check(f.Path != "", "files", "each file must have a path")
+		v.Check(len(f.Path) <= MaxFilePathBytes, "files", "each path must not be more than 255 bytes")
+		v.Check(validFilePath(f.Path), "files", "paths must be relative, without '..', '.' or empty segments")
+		v.Check(!optionLikePath(f.Path), "files", "path segments must not start with '-'")
+		v.Check(len(f.Content) <= MaxFileBytes, "files", "each file must not be more than 256KB")
+		v.Check(!seen[f.Path], "files", "must not contain the same path twice")
+		seen[f.Path] = true
+	}
+	v.Check(total <= MaxTotalFileBytes, "files", "must not be more than 512KB in total")
+
+	// A file cannot also be the directory of another one.
+	for _, f := range files {
+		for dir := path.Dir(f.Path); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			v.Check(!seen[dir], "files", "a path must not be used as both a file and a directory")
+		}
+	}
+
+	v.Check(entryPoint != "", "entry_point", "must be provided")
+	v.Check(entryPoint == "" || seen[entryPoint], "entry_point", "must be the path of one of the files")
+}
+
+func validFilePath(p string) bool {
+	if strings.ContainsAny(p, "\\\x00") || !filepath.IsLocal(p) {
+		return false
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func optionLikePath(p string) bool {
+	for _, segment := range strings.Split(p, "/") {
+		if strings.HasPrefix(segment, "-") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFiles materialises files under dir. Everything is made writable for
+// everybody, since the sandbox user is usually not the one running this
+// process and the compile phase copies its output back over these files.
+func writeFiles(dir string, files []File) error {
+	for _, f := range files {
+		name := filepath.Join(dir, filepath.FromSlash(f.Path))
+		err := os.MkdirAll(filepath.Dir(name), 0o777)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(name, []byte(f.Content), 0o666)
+		if err != nil {
+			return err
+		}
+	}
+
+	return filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.Chmod(name, 0o777)
+		}
+		return os.Chmod(name, 0o666)
+	})
+}
+
+// expandCommand fills in the placeholders of a configured command:
+//
+//	{file}   the entry point, relative to /app
+//	{name}   the entry point's base name without its extension
+//	{files}  every file with the language's extension, one argument each
+//	{test}   the test file of test runs
+func (l Language) expandCommand(command []string, files []File, entryPoint string) []string {
+	name := strings.TrimSuffix(path.Base(entryPoint), path.Ext(entryPoint))
+
+	var expanded []string
+	for _, arg := range command {
+		if arg == "{files}" {
+			for _, f := range files {
+				if path.Ext(f.Path) == l.Extension {
+					expanded = append(expanded, f.Path)
+				}
+			}
+			continue
+		}
+		arg = strings.ReplaceAll(arg, "{file}", entryPoint)
+		arg = strings.ReplaceAll(arg, "{name}", name)
+		if l.Test != nil {
+			arg = strings.ReplaceAll(arg, "{test}", l.Test.FileName)
+		}
+		expanded = append(expanded, arg)
+	}
+	return expanded
+}
+
+// sourceFiles returns the files of req, turning a single-file request into
+// one file named after the language's FileName. The test file of a test run
+// is added next to them.
+func (l Language) sourceFiles(req ExecuteRequest) ([]File, string, error) {
+	files := []File{{Path: l.FileName, Content: req.Code}}
+	entryPoint := l.FileName
+	if len(req.Files) > 0 {
+		files = slices.Clone(req.Files)
+		if req.EntryPoint != "" {
+			entryPoint = req.EntryPoint
+		}
+		if !slices.ContainsFunc(files, func(f File) bool { return f.Path == entryPoint }) {
+			return nil, "", fmt.Errorf("entry point %q is not one of the files", entryPoint)
+		}
+	}
+
+	if req.TestCode != "" {
+		if l.Test == nil {
+			return nil, "", fmt.Errorf("language %s has no test framework", l.ID)
+		}
+		if slices.ContainsFunc(files, func(f File) bool { return f.Path == l.Test.FileName }) {
+			return nil, "", fmt.Errorf("%s is reserved for the test file", l.Test.FileName)
+		}
+		files = append(files, File{Path: l.Test.FileName, Content: req.TestCode})
+	}
+	return files, entryPoint, nil
+}
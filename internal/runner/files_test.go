@@ -0,0 +1,33 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/VJ-2303/code-runner/internal/validator"
+)
+
+func TestValidateFilesPaths(t *testing.T) {
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{"main.c", true},
+		{"src/util.c", true},
+		{"src/my-util.c", true},
+		{"/etc/passwd", false},
+		{"../main.c", false},
+		{"src//main.c", false},
+		{"./main.c", false},
+		{"-o", false},
+		{"-fplugin=x.so", false},
+		{"src/-x.c", false},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateFiles(v, []File{{Path: tt.path, Content: "int main() {}"}}, tt.path)
+		if v.Valid() != tt.valid {
+			t.Errorf("%q: got valid %v, want %v (%v)", tt.path, v.Valid(), tt.valid, v.FieldErrors)
+		}
+	}
+}
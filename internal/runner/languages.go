@@ -0,0 +1,481 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	LanguageIDRX = regexp.MustCompile(`^[a-z0-9][a-z0-9+#-]*$`)
+	VersionIDRX  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.+-]*$`)
+	// digestRX matches the digest an image reference is pinned by.
+	digestRX = regexp.MustCompile(`@sha256:[0-9a-f]{64}$`)
+	// imageIDRX matches the ID of a local image, which pins test images
+	// that are built on the host rather than pulled from a registry.
+	imageIDRX = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+)
+
+// Duration is a time.Duration that reads and writes as a string such as "10s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return errors.New(`duration must be a string such as "10s"`)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Limits bounds the resources of a single container phase.
+type Limits struct {
+	Timeout  Duration `json:"timeout"`
+	MemoryMB int      `json:"memory_mb"`
+	CPUs     float64  `json:"cpus"`
+}
+
+func (l Limits) dockerMemory() string {
+	return fmt.Sprintf("%dm", l.MemoryMB)
+}
+
+func (l Limits) dockerCPUs() string {
+	return strconv.FormatFloat(l.CPUs, 'f', -1, 64)
+}
+
+func (l Limits) memoryBytes() int64 {
+	return int64(l.MemoryMB) * 1024 * 1024
+}
+
+func (l Limits) nanoCPUs() int64 {
+	return int64(l.CPUs * 1e9)
+}
+
+// withDefaults fills every zero field of l from def.
+func (l Limits) withDefaults(def Limits) Limits {
+	if l.Timeout == 0 {
+		l.Timeout = def.Timeout
+	}
+	if l.MemoryMB == 0 {
+		l.MemoryMB = def.MemoryMB
+	}
+	if l.CPUs == 0 {
+		l.CPUs = def.CPUs
+	}
+	return l
+}
+
+// capped lowers every field of l to the one of caps, where that is set.
+func (l Limits) capped(caps Limits) Limits {
+	if caps.Timeout > 0 {
+		l.Timeout = min(l.Timeout, caps.Timeout)
+	}
+	if caps.MemoryMB > 0 {
+		l.MemoryMB = min(l.MemoryMB, caps.MemoryMB)
+	}
+	if caps.CPUs > 0 {
+		l.CPUs = min(l.CPUs, caps.CPUs)
+	}
+	return l
+}
+
+var (
+	DefaultRunLimits     = Limits{Timeout: Duration(DefaultTimeout), MemoryMB: 128, CPUs: 0.5}
+	DefaultMaxRunLimits  = Limits{Timeout: Duration(30 * time.Second), MemoryMB: 512, CPUs: 1}
+	DefaultCompileLimits = Limits{Timeout: Duration(10 * time.Second), MemoryMB: 512, CPUs: 1}
+	DefaultInstallLimits = Limits{Timeout: Duration(2 * time.Minute), MemoryMB: 512, CPUs: 1}
+	DefaultTestLimits    = Limits{Timeout: Duration(30 * time.Second), MemoryMB: 512, CPUs: 1}
+)
+
+// Report formats a test framework can produce.
+const (
+	ReportJUnit  = "junit"
+	ReportTAP    = "tap"
+	ReportGoJSON = "go-json"
+	ReportRSpec  = "rspec-json"
+)
+
+var ReportFormats = []string{ReportJUnit, ReportTAP, ReportGoJSON, ReportRSpec}
+
+// TestFramework describes how to run a test file against the code with the
+// language's native framework. Command replaces the compile and run phases
+// and must write a Format report to /runner/report. Image defaults to the
+// language's image; frameworks that are not part of it come from the run's
+// dependencies or from an image of their own.
+type TestFramework struct {
+	FileName string   `json:"file_name"`
+	Image    string   `json:"image,omitempty"`
+	Command  []string `json:"command"`
+	Format   string   `json:"format"`
+	Limits   Limits   `json:"limits"`
+}
+
+// REPL drivers, the scripts of the repl directory that keep an interpreter
+// running between the cells of a session.
+const (
+	DriverPython = "python"
+	DriverRuby   = "ruby"
+	DriverNode   = "node"
+)
+
+var REPLDrivers = []string{DriverPython, DriverRuby, DriverNode}
+
+// REPLConfig describes how a language keeps an interpreter running for a session
+// of code cells. Command must run Driver, which the sandbox mounts at
+// /runner/repl. The run limits bound the container and each of its cells.
+type REPLConfig struct {
+	Driver  string   `json:"driver"`
+	Command []string `json:"command"`
+}
+
+// LanguageVersion is one version of a language that runs may pick. Its
+// images must be pinned by digest, as in "python:3.12-alpine@sha256:...", so
+// results do not change when the tag moves on to a new build; cmd/pin-images
+// pins the images of a languages file. TestImage, when set, replaces the
+// image of the language's test framework for this version. Test images built
+// on the host and never pushed may be given by their image ID instead.
+type LanguageVersion struct {
+	ID        string `json:"id"`
+	Image     string `json:"image"`
+	TestImage string `json:"test_image,omitempty"`
+}
+
+// Dependencies describes how a language installs the packages listed in a
+// manifest file. InstallCommand runs in /app next to the manifest and must
+// put everything under /deps, which later phases see read-only. The values
+// of InstallEnv may use {mirror} for the package mirror's URL, Env is added
+// to the compile and run phases so they find the packages.
+type Dependencies struct {
+	Manifest       string            `json:"manifest"`
+	InstallCommand []string          `json:"install_command"`
+	InstallEnv     map[string]string `json:"install_env,omitempty"`
+	InstallLimits  Limits            `json:"install_limits"`
+	Env            map[string]string `json:"env,omitempty"`
+}
+
+// Language describes everything needed to validate, store and execute code
+// written in one language. It is the single source of truth for which
+// languages the API accepts.
+//
+// Commands may use the placeholders {file}, {name}, {files} and {test}, see
+// expandCommand. FileName is the entry point of single-file runs and the
+// default one of multi-file runs.
+//
+// RunLimits are what a run gets unless it asks for other limits, which may
+// be up to MaxRunLimits.
+//
+// A language either runs in Image, as its only Version, or offers several
+// Versions with Version as the default. The registry fills in the other:
+// Image becomes the default's image, or Versions lists just Image. Like
+// those of versions, Image and the test framework's image must be pinned by
+// digest.
+type Language struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	Extension      string   `json:"extension"`
+	Image          string   `json:"image,omitempty"`
+	Version        string   `json:"version"`
+	FileName       string   `json:"file_name"`
+	CompileCommand []string `json:"compile_command,omitempty"`
+	CompileLimits  Limits   `json:"compile_limits"`
+	RunCommand     []string `json:"run_command"`
+	RunLimits      Limits   `json:"run_limits"`
+	MaxRunLimits   Limits   `json:"max_run_limits"`
+
+	Versions     []LanguageVersion `json:"versions,omitempty"`
+	Dependencies *Dependencies     `json:"dependencies,omitempty"`
+	Test         *TestFramework    `json:"test,omitempty"`
+	REPL         *REPLConfig       `json:"repl,omitempty"`
+}
+
+func (l Language) Compiled() bool {
+	return len(l.CompileCommand) > 0
+}
+
+// WithVersion returns l set up for the version with the given ID, or for its
+// default version when id is empty.
+func (l Language) WithVersion(id string) (Language, bool) {
+	if id == "" {
+		id = l.Version
+	}
+	for _, v := range l.Versions {
+		if v.ID == id {
+			l.Image = v.Image
+			l.Version = v.ID
+			if v.TestImage != "" && l.Test != nil {
+				test := *l.Test
+				test.Image = v.TestImage
+				l.Test = &test
+			}
+			return l, true
+		}
+	}
+	return l, id == l.Version
+}
+
+// VersionIDs returns the IDs of every version of l, ready for
+// validator.PermittedValue.
+func (l Language) VersionIDs() []string {
+	ids := make([]string, len(l.Versions))
+	for i, v := range l.Versions {
+		ids[i] = v.ID
+	}
+	return ids
+}
+
+// LimitCaps returns the highest run limits a request for l may ask for: the
+// language's maximum, lowered by whatever fields of tier are set.
+func (l Language) LimitCaps(tier Limits) Limits {
+	return l.MaxRunLimits.capped(tier)
+}
+
+// EffectiveLimits returns the run limits of a request for l that asked for
+// requested. Fields it left zero take the language's run limits, lowered to
+// caps where those are higher.
+func (l Language) EffectiveLimits(requested, caps Limits) Limits {
+	return requested.withDefaults(l.RunLimits.capped(caps))
+}
+
+// EffectiveTestLimits is EffectiveLimits for a test run, whose zero fields
+// take the limits of l's test framework instead.
+func (l Language) EffectiveTestLimits(requested, caps Limits) Limits {
+	return requested.withDefaults(l.Test.Limits.capped(caps))
+}
+
+// pinnedTestImage reports whether image is pinned by a digest or is a local
+// image ID.
+func pinnedTestImage(image string) bool {
+	return digestRX.MatchString(image) || imageIDRX.MatchString(image)
+}
+
+func (l Language) validate() error {
+	switch {
+	case !LanguageIDRX.MatchString(l.ID):
+		return fmt.Errorf("language id %q must be lowercase letters, digits, '+', '#' or '-'", l.ID)
+	case l.Name == "":
+		return fmt.Errorf("language %q: name must be provided", l.ID)
+	case !strings.HasPrefix(l.Extension, "."):
+		return fmt.Errorf("language %q: extension must start with a dot", l.ID)
+	case l.Image == "" && len(l.Versions) == 0:
+		return fmt.Errorf("language %q: image or versions must be provided", l.ID)
+	case l.Image != "" && len(l.Versions) > 0:
+		return fmt.Errorf("language %q: image must not be set together with versions", l.ID)
+	case l.Image != "" && !digestRX.MatchString(l.Image):
+		return fmt.Errorf("language %q: image %q must be pinned by a sha256 digest", l.ID, l.Image)
+	case l.FileName == "" || strings.ContainsAny(l.FileName, `/\`):
+		return fmt.Errorf("language %q: file_name must be a plain file name", l.ID)
+	case len(l.RunCommand) == 0:
+		return fmt.Errorf("language %q: run_command must be provided", l.ID)
+	}
+	if len(l.Versions) > 0 {
+		seen := make(map[string]bool, len(l.Versions))
+		for _, v := range l.Versions {
+			switch {
+			case !VersionIDRX.MatchString(v.ID):
+				return fmt.Errorf("language %q: version id %q must be letters, digits, '.', '+' or '-'", l.ID, v.ID)
+			case seen[v.ID]:
+				return fmt.Errorf("language %q: version %q is defined more than once", l.ID, v.ID)
+			case v.Image == "":
+				return fmt.Errorf("language %q: version %q needs an image", l.ID, v.ID)
+			case !digestRX.MatchString(v.Image):
+				return fmt.Errorf("language %q: image %q of version %q must be pinned by a sha256 digest", l.ID, v.Image, v.ID)
+			case v.TestImage != "" && !pinnedTestImage(v.TestImage):
+				return fmt.Errorf("language %q: test image %q of version %q must be pinned by a sha256 digest or be an image ID", l.ID, v.TestImage, v.ID)
+			}
+			seen[v.ID] = true
+		}
+		if !seen[l.Version] {
+			return fmt.Errorf("language %q: the default version %q is not one of its versions", l.ID, l.Version)
+		}
+	}
+	limits := []Limits{l.CompileLimits, l.RunLimits, l.MaxRunLimits}
+	if d := l.Dependencies; d != nil {
+		switch {
+		case !slices.Contains(manifestNames, d.Manifest):
+			return fmt.Errorf("language %q: dependency manifest must be one of %s", l.ID, strings.Join(manifestNames, ", "))
+		case len(d.InstallCommand) == 0:
+			return fmt.Errorf("language %q: dependencies need an install_command", l.ID)
+		}
+		limits = append(limits, d.InstallLimits)
+	}
+	if t := l.Test; t != nil {
+		switch {
+		case t.FileName == "" || strings.ContainsAny(t.FileName, `/\`) || t.FileName == l.FileName:
+			return fmt.Errorf("language %q: the test file_name must be a plain file name other than the code's", l.ID)
+		case len(t.Command) == 0:
+			return fmt.Errorf("language %q: the test command must be provided", l.ID)
+		case !slices.Contains(ReportFormats, t.Format):
+			return fmt.Errorf("language %q: the test format must be one of %s", l.ID, strings.Join(ReportFormats, ", "))
+		case t.Image != "" && !pinnedTestImage(t.Image):
+			return fmt.Errorf("language %q: test image %q must be pinned by a sha256 digest or be an image ID", l.ID, t.Image)
+		}
+		limits = append(limits, t.Limits)
+	}
+	if r := l.REPL; r != nil {
+		switch {
+		case !slices.Contains(REPLDrivers, r.Driver):
+			return fmt.Errorf("language %q: the repl driver must be one of %s", l.ID, strings.Join(REPLDrivers, ", "))
+		case len(r.Command) == 0:
+			return fmt.Errorf("language %q: the repl command must be provided", l.ID)
+		}
+	}
+	for _, limits := range limits {
+		if limits.Timeout < 0 || limits.MemoryMB < 0 || limits.CPUs < 0 {
+			return fmt.Errorf("language %q: limits must not be negative", l.ID)
+		}
+	}
+	return nil
+}
+
+// Registry holds the configured languages. It is safe for concurrent use
+// and can be swapped out wholesale by Reload; callers get copies, so a
+// reload never changes a run that is already in progress.
+type Registry struct {
+	mu        sync.RWMutex
+	languages map[string]Language
+}
+
+func NewRegistry(languages ...Language) *Registry {
+	r := &Registry{}
+	r.set(languages)
+	return r
+}
+
+func (r *Registry) set(languages []Language) {
+	m := make(map[string]Language, len(languages))
+	for _, l := range languages {
+		if len(l.Versions) == 0 {
+			l.Versions = []LanguageVersion{{ID: l.Version, Image: l.Image}}
+		} else {
+			l.Versions = slices.Clone(l.Versions)
+			for _, v := range l.Versions {
+				if v.ID == l.Version {
+					l.Image = v.Image
+				}
+			}
+		}
+		l.CompileLimits = l.CompileLimits.withDefaults(DefaultCompileLimits)
+		l.RunLimits = l.RunLimits.withDefaults(DefaultRunLimits)
+		l.MaxRunLimits = l.MaxRunLimits.withDefaults(DefaultMaxRunLimits)
+		if l.Dependencies != nil {
+			deps := *l.Dependencies
+			deps.InstallLimits = deps.InstallLimits.withDefaults(DefaultInstallLimits)
+			l.Dependencies = &deps
+		}
+		if l.Test != nil {
+			test := *l.Test
+			test.Limits = test.Limits.withDefaults(DefaultTestLimits)
+			l.Test = &test
+		}
+		m[l.ID] = l
+	}
+
+	r.mu.Lock()
+	r.languages = m
+	r.mu.Unlock()
+}
+
+// LoadRegistry reads language definitions from a JSON file.
+func LoadRegistry(path string) (*Registry, error) {
+	languages, err := readLanguagesFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewRegistry(languages...), nil
+}
+
+// Reload replaces the registry's languages with the contents of path. On
+// error the current languages are kept.
+func (r *Registry) Reload(path string) error {
+	languages, err := readLanguagesFile(path)
+	if err != nil {
+		return err
+	}
+	r.set(languages)
+	return nil
+}
+
+func readLanguagesFile(path string) ([]Language, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var file struct {
+		Languages []Language `json:"languages"`
+	}
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(file.Languages) == 0 {
+		return nil, fmt.Errorf("%s: no languages defined", path)
+	}
+
+	seen := make(map[string]bool, len(file.Languages))
+	for _, l := range file.Languages {
+		err := l.validate()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if seen[l.ID] {
+			return nil, fmt.Errorf("%s: language %q is defined more than once", path, l.ID)
+		}
+		seen[l.ID] = true
+	}
+	return file.Languages, nil
+}
+
+func (r *Registry) Get(id string) (Language, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	l, ok := r.languages[id]
+	return l, ok
+}
+
+// All returns every language ordered by ID.
+func (r *Registry) All() []Language {
+	r.mu.RLock()
+	all := make([]Language, 0, len(r.languages))
+	for _, l := range r.languages {
+		all = append(all, l)
+	}
+	r.mu.RUnlock()
+
+	slices.SortFunc(all, func(a, b Language) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return all
+}
+
+// IDs returns the sorted language IDs, ready for validator.PermittedValue.
+func (r *Registry) IDs() []string {
+	all := r.All()
+	ids := make([]string, len(all))
+	for i, l := range all {
+		ids[i] = l.ID
+	}
+	return ids
+}
@@ -4,9 +4,10 @@ import "context"
 
 type MockRunner struct{}
 
-func (m *MockRunner) Run(ctx context.Context, code string, language string) (*ExecuteResult, error) {
+func (m *MockRunner) Run(ctx context.Context, req ExecuteRequest) (*ExecuteResult, error) {
 	return &ExecuteResult{
-		Output: "Mock Output: " + code,
+		Status: StatusOK,
+		Output: "Mock Output: " + req.Code,
 		Error:  "",
 	}, nil
 }
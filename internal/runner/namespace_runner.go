@@ -0,0 +1,62 @@
+package runner
+
+import (
+	"errors"
+)
+
+// NamespaceOptions configures NamespaceRunner.
+type NamespaceOptions struct {
+	// Bwrap is the bubblewrap binary, looked up in PATH when not absolute.
+	Bwrap string
+	// RootfsDir holds one extracted root filesystem per image, named
+	// after the image with '/', ':' and '@' replaced by '_'. The rootfs
+	// for "python:alpine" is RootfsDir/python_alpine, for example made
+	// with "docker export $(docker create python:alpine) | tar -x". An
+	// optional RootfsDir/python_alpine.env file lists the image's
+	// environment as KEY=VALUE lines.
+	RootfsDir string
+	// CgroupRoot is a cgroup v2 directory delegated to this process, in
+	// which one child cgroup is created per phase. It must not contain
+	// processes itself.
+	CgroupRoot string
+	Security   SecurityProfile
+	// Output bounds and cleans up program output, as in DockerOptions.
+	Output OutputOptions
+}
+
+// NamespaceRunner executes each phase of a run in Linux namespaces set up by
+// bubblewrap, with limits enforced by a cgroup v2 of its own. It needs no
+// Docker daemon but gives the same memory, CPU, network and timeout
+// guarantees as DockerRunner.
+type NamespaceRunner struct {
+	sandbox
+}
+
+func NewNamespaceRunner(languages *Registry, opts NamespaceOptions) (*NamespaceRunner, error) {
+	err := opts.Security.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = opts.Output.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Security.SeccompProfile != "" || opts.Security.Runtime != "" {
+		return nil, errors.New("seccomp profiles and OCI runtimes are only supported by the docker backend")
+	}
+	if opts.RootfsDir == "" || opts.CgroupRoot == "" {
+		return nil, errors.New("the namespace runner needs a rootfs dir and a cgroup root")
+	}
+
+	engine, err := newBwrapEngine(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NamespaceRunner{sandbox{
+		languages: languages,
+		security:  opts.Security,
+		engine:    engine,
+		output:    opts.Output.withDefaults(),
+	}}, nil
+}
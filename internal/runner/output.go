@@ -0,0 +1,249 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// What OutputOptions.Control does with control characters and terminal
+// escape sequences.
+const (
+	// ControlStrip removes them, escape sequences as a whole.
+	ControlStrip = "strip"
+	// ControlEscape turns each control character into a visible \xNN.
+	ControlEscape = "escape"
+)
+
+// OutputOptions bounds and cleans up what programs write. A phase that
+// writes more than StdoutBytes or StderrBytes to either stream is killed,
+// and the output it wrote up to the limit is kept and marked truncated.
+//
+// Output always reaches the result as valid UTF-8, with line breaks
+// normalised to \n and other control characters handled as Control says.
+type OutputOptions struct {
+	StdoutBytes int
+	StderrBytes int
+	Control     string
+}
+
+var DefaultOutputOptions = OutputOptions{StdoutBytes: 1 << 20, StderrBytes: 1 << 20, Control: ControlStrip}
+
+func (o OutputOptions) Validate() error {
+	if o.StdoutBytes < 0 || o.StderrBytes < 0 {
+		return errors.New("output limits must not be negative")
+	}
+	if o.Control != "" && o.Control != ControlStrip && o.Control != ControlEscape {
+		return fmt.Errorf("output control must be %s or %s", ControlStrip, ControlEscape)
+	}
+	return nil
+}
+
+// withDefaults fills every zero field of o from DefaultOutputOptions.
+func (o OutputOptions) withDefaults() OutputOptions {
+	if o.StdoutBytes == 0 {
+		o.StdoutBytes = DefaultOutputOptions.StdoutBytes
+	}
+	if o.StderrBytes == 0 {
+		o.StderrBytes = DefaultOutputOptions.StderrBytes
+	}
+	if o.Control == "" {
+		o.Control = DefaultOutputOptions.Control
+	}
+	return o
+}
+
+// sanitize cleans up a complete piece of output.
+func (o OutputOptions) sanitize(s string) string {
+	sz := o.sanitizer()
+	return sz.write([]byte(s)) + sz.flush()
+}
+
+func (o OutputOptions) sanitizer() *sanitizer {
+	return &sanitizer{escape: o.Control == ControlEscape}
+}
+
+var errOutputLimit = errors.New("output limit exceeded")
+
+// limitedBuffer keeps at most max bytes and drops the rest, so a chatty
+// program cannot grow the API's memory without bound.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+	// exceeded, when set, is called the first time a write goes past max.
+	exceeded func()
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	remaining := b.max - b.buf.Len()
+	if len(p) > remaining {
+		if !b.truncated && b.exceeded != nil {
+			b.exceeded()
+		}
+		b.truncated = true
+		if remaining > 0 {
+			b.buf.Write(p[:remaining])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+// streamWriter emits whatever its buffer accepts as soon as it is written,
+// cleaned up by sanitizer.
+type streamWriter struct {
+	buf       *limitedBuffer
+	stream    string
+	emit      func(OutputEvent)
+	sanitizer *sanitizer
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	before := w.buf.buf.Len()
+	n, err := w.buf.Write(p)
+	accepted := p[:w.buf.buf.Len()-before]
+
+	if data := w.sanitizer.write(accepted); data != "" {
+		w.emit(OutputEvent{Stream: w.stream, Data: data, Time: time.Now()})
+	}
+	return n, err
+}
+
+const (
+	stateText = iota
+	// stateEscape follows an ESC.
+	stateEscape
+	// stateCSI is inside a control sequence such as a colour, ESC [ ... m.
+	stateCSI
+	// stateString is inside a string sequence such as a window title,
+	// ESC ] ... BEL, and stateStringEscape follows an ESC inside one.
+	stateString
+	stateStringEscape
+)
+
+// maxSequenceRunes bounds an escape sequence. A longer one is most likely no
+// sequence at all, and the text after it is kept again.
+const maxSequenceRunes = 256
+
+// sanitizer turns output into valid UTF-8 with \n line breaks and strips or
+// escapes the control characters. It keeps its state between writes, so
+// sequences split across them are handled as well.
+type sanitizer struct {
+	escape  bool
+	state   int
+	seqLen  int
+	cr      bool
+	partial []byte
+}
+
+// write returns the clean text of p. An incomplete UTF-8 sequence at the end
+// of p is held back until the next write completes it.
+func (s *sanitizer) write(p []byte) string {
+	data := p
+	if len(s.partial) > 0 {
+		data = append(s.partial, p...)
+		s.partial = nil
+	}
+
+	var b strings.Builder
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 && !utf8.FullRune(data) {
+			s.partial = bytes.Clone(data)
+			break
+		}
+		data = data[size:]
+		s.rune(&b, r)
+	}
+	return b.String()
+}
+
+// flush returns what is left once the output has ended.
+func (s *sanitizer) flush() string {
+	if len(s.partial) == 0 {
+		return ""
+	}
+	s.partial = nil
+	var b strings.Builder
+	s.rune(&b, utf8.RuneError)
+	return b.String()
+}
+
+func (s *sanitizer) rune(b *strings.Builder, r rune) {
+	cr := s.cr
+	s.cr = false
+
+	if s.state != stateText {
+		s.seqLen++
+		if s.seqLen > maxSequenceRunes {
+			s.state = stateText
+		}
+	}
+
+	switch s.state {
+	case stateEscape:
+		switch {
+		case r == '[':
+			s.state = stateCSI
+		case r == ']' || r == 'P' || r == 'X' || r == '^' || r == '_':
+			s.state = stateString
+		case r >= 0x20 && r <= 0x2f:
+			// An intermediate byte, as in ESC ( B.
+		default:
+			s.state = stateText
+		}
+		return
+	case stateCSI:
+		if r >= 0x20 && r <= 0x3f {
+			return
+		}
+		s.state = stateText
+		if r >= 0x40 && r <= 0x7e {
+			return
+		}
+		// Anything else cuts the sequence short and is text again.
+	case stateString:
+		switch r {
+		case 0x07:
+			s.state = stateText
+		case 0x1b:
+			s.state = stateStringEscape
+		}
+		return
+	case stateStringEscape:
+		s.state = stateString
+		if r == '\\' {
+			s.state = stateText
+		}
+		return
+	}
+
+	switch {
+	case r == '\n':
+		if !cr {
+			b.WriteByte('\n')
+		}
+	case r == '\r':
+		b.WriteByte('\n')
+		s.cr = true
+	case r == '\t':
+		b.WriteByte('\t')
+	case r == 0x1b && !s.escape:
+		s.state = stateEscape
+		s.seqLen = 0
+	case r < 0x20 || r == 0x7f || (r >= 0x80 && r <= 0x9f):
+		if s.escape {
+			fmt.Fprintf(b, `\x%02x`, r)
+		}
+	default:
+		b.WriteRune(r)
+	}
+}
@@ -0,0 +1,268 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanitizer(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		strip  string
+		escape string
+	}{
+		{"text", []string{"hello, wörld\n"}, "hello, wörld\n", "hello, wörld\n"},
+		{"split rune", []string{"h\xc3", "\xa9llo"}, "héllo", "héllo"},
+		{"rune split three ways", []string{"\xf0\x9f", "\x98", "\x80!"}, "😀!", "😀!"},
+		{"invalid byte", []string{"a\xffb"}, "a\uFFFDb", "a\uFFFDb"},
+		{"rune cut off at the end", []string{"ab\xe2\x82"}, "ab\uFFFD", "ab\uFFFD"},
+		{"colour", []string{"\x1b[1;31mred\x1b[0m\n"}, "red\n", `\x1b[1;31mred\x1b[0m` + "\n"},
+		{"split colour", []string{"\x1b", "[3", "1mred\x1b[", "0m"}, "red", `\x1b[31mred\x1b[0m`},
+		{"cursor movement", []string{"50%\x1b[2K\x1b[1G100%"}, "50%100%", `50%\x1b[2K\x1b[1G100%`},
+		{"charset", []string{"\x1b(Btext"}, "text", `\x1b(Btext`},
+		{"title", []string{"\x1b]0;ti", "tle\x07text"}, "text", `\x1b]0;title\x07text`},
+		{"hyperlink", []string{"\x1b]8;;https://example.com\x1b", "\\link\x1b]8;;\x1b\\"}, "link", `\x1b]8;;https://example.com\x1b\link\x1b]8;;\x1b\`},
+		{"sequence cut short", []string{"\x1b[31\nnext"}, "\nnext", `\x1b[31` + "\nnext"},
+		{"runaway sequence", []string{"\x1b]" + strings.Repeat("x", 300)}, strings.Repeat("x", 45), `\x1b]` + strings.Repeat("x", 300)},
+		{"bare carriage return", []string{"a\rb"}, "a\nb", "a\nb"},
+		{"crlf", []string{"a\r\nb\r\n"}, "a\nb\n", "a\nb\n"},
+		{"split crlf", []string{"a\r", "\nb"}, "a\nb", "a\nb"},
+		{"blank lines", []string{"a\r\r\n\nb"}, "a\n\n\nb", "a\n\n\nb"},
+		{"control characters", []string{"a\x00b\x7fc\td\x08"}, "abc\td", `a\x00b\x7fc` + "\td" + `\x08`},
+		{"c1 control", []string{"a\u0085b"}, "ab", `a\x85b`},
+	}
+
+	for _, tt := range tests {
+		for _, mode := range []string{ControlStrip, ControlEscape} {
+			t.Run(tt.name+"/"+mode, func(t *testing.T) {
+				want := tt.strip
+				if mode == ControlEscape {
+					want = tt.escape
+				}
+
+				s := OutputOptions{Control: mode}.sanitizer()
+				var got strings.Builder
+				for _, w := range tt.writes {
+					got.WriteString(s.write([]byte(w)))
+				}
+				got.WriteString(s.flush())
+				if got.String() != want {
+					t.Fatalf("got %q, want %q", got.String(), want)
+				}
+
+				if whole := (OutputOptions{Control: mode}).sanitize(strings.Join(tt.writes, "")); whole != want {
+					t.Fatalf("got %q in one piece, want %q", whole, want)
+				}
+			})
+		}
+	}
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	tests := []struct {
+		name      string
+		max       int
+		writes    []string
+		want      string
+		truncated bool
+	}{
+		{"under the limit", 10, []string{"hello"}, "hello", false},
+		{"at the limit", 5, []string{"hel", "lo"}, "hello", false},
+		{"just over the limit", 5, []string{"hello!"}, "hello", true},
+		{"over the limit in a later write", 5, []string{"hel", "lo!", "more"}, "hello", true},
+		{"empty write at the limit", 5, []string{"hello", ""}, "hello", false},
+		{"write after the limit", 5, []string{"hello", "!"}, "hello", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exceeded := 0
+			b := &limitedBuffer{max: tt.max, exceeded: func() { exceeded++ }}
+			for _, w := range tt.writes {
+				// Writes past the limit still succeed, so the program's
+				// output is drained until it is killed.
+				n, err := b.Write([]byte(w))
+				if n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v, want %d, nil", w, n, err, len(w))
+				}
+			}
+
+			if b.String() != tt.want || b.truncated != tt.truncated {
+				t.Fatalf("got %q, truncated %t, want %q, truncated %t", b.String(), b.truncated, tt.want, tt.truncated)
+			}
+			if want := map[bool]int{false: 0, true: 1}[tt.truncated]; exceeded != want {
+				t.Fatalf("exceeded was called %d times, want %d", exceeded, want)
+			}
+		})
+	}
+}
+
+func TestStreamWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		max    int
+		writes []string
+		events []string
+		output string
+	}{
+		{"text", 100, []string{"one\n", "two\n"}, []string{"one\n", "two\n"}, "one\ntwo\n"},
+		{"split rune", 100, []string{"h\xc3", "\xa9llo"}, []string{"h", "éllo"}, "h\xc3\xa9llo"},
+		{"split colour", 100, []string{"\x1b[3", "1mred"}, []string{"red"}, "\x1b[31mred"},
+		{"split crlf", 100, []string{"a\r", "\nb"}, []string{"a\n", "b"}, "a\r\nb"},
+		{"at the limit", 6, []string{"abc", "def"}, []string{"abc", "def"}, "abcdef"},
+		{"over the limit", 6, []string{"abc", "defg", "hij"}, []string{"abc", "def"}, "abcdef"},
+		{"limit inside a rune", 2, []string{"h\xc3\xa9"}, []string{"h"}, "h\xc3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []string
+			buf := &limitedBuffer{max: tt.max}
+			w := &streamWriter{
+				buf:    buf,
+				stream: StreamStdout,
+				emit: func(e OutputEvent) {
+					if e.Stream != StreamStdout {
+						t.Errorf("got an event on %q, want %q", e.Stream, StreamStdout)
+					}
+					events = append(events, e.Data)
+				},
+				sanitizer: OutputOptions{}.withDefaults().sanitizer(),
+			}
+			for _, p := range tt.writes {
+				n, err := w.Write([]byte(p))
+				if n != len(p) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v, want %d, nil", p, n, err, len(p))
+				}
+			}
+
+			if !slices.Equal(events, tt.events) {
+				t.Fatalf("emitted %q, want %q", events, tt.events)
+			}
+			if buf.String() != tt.output {
+				t.Fatalf("kept %q, want %q", buf.String(), tt.output)
+			}
+		})
+	}
+}
+
+// outputEngine writes stdout and stderr in small chunks, like a container
+// producing output, and is stopped by the cancellation of its context.
+type outputEngine struct {
+	stdout, stderr string
+}
+
+func (e *outputEngine) run(ctx context.Context, spec containerSpec) (*containerState, error) {
+	for _, out := range []struct {
+		w    interface{ Write([]byte) (int, error) }
+		data string
+	}{{spec.stdout, e.stdout}, {spec.stderr, e.stderr}} {
+		for chunk := range slices.Chunk([]byte(out.data), 1024) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			out.w.Write(chunk)
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	now := time.Now()
+	return &containerState{StartedAt: now, FinishedAt: now}, nil
+}
+
+func (e *outputEngine) reap(ctx context.Context, olderThan time.Duration) (int, error) {
+	return 0, nil
+}
+
+func TestRunOutputLimit(t *testing.T) {
+	python := Language{
+		ID:         "python",
+		Image:      "python:3.13-alpine@sha256:" + strings.Repeat("0", 64),
+		Version:    "3.13",
+		FileName:   "main.py",
+		RunCommand: []string{"python", "{file}"},
+		RunLimits:  Limits{Timeout: Duration(10 * time.Second), MemoryMB: 128, CPUs: 1},
+	}
+	output := OutputOptions{StdoutBytes: 4096, StderrBytes: 1024}
+
+	tests := []struct {
+		name   string
+		engine outputEngine
+		status Status
+		output string
+		error  string
+	}{
+		{"under the limits", outputEngine{stdout: "hello\n", stderr: "oops\n"}, StatusOK, "hello\n", "oops\n"},
+		{"at the limits", outputEngine{stdout: strings.Repeat("x", 4096), stderr: strings.Repeat("e", 1024)}, StatusOK, strings.Repeat("x", 4096), strings.Repeat("e", 1024)},
+		{"stdout just over", outputEngine{stdout: strings.Repeat("x", 4097), stderr: "never written"}, StatusOutputLimitExceeded, strings.Repeat("x", 4096), ""},
+		{"stderr just over", outputEngine{stdout: "hello\n", stderr: strings.Repeat("e", 1025)}, StatusOutputLimitExceeded, "hello\n", strings.Repeat("e", 1024)},
+		{"far over", outputEngine{stdout: strings.Repeat("x", 1<<20)}, StatusOutputLimitExceeded, strings.Repeat("x", 4096), ""},
+	}
+
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			name := tt.name
+			if stream {
+				name += "/stream"
+			}
+			t.Run(name, func(t *testing.T) {
+				s := &sandbox{
+					languages: NewRegistry(python),
+					security:  DefaultSecurityProfile(),
+					engine:    &tt.engine,
+					output:    output.withDefaults(),
+				}
+
+				var streamed strings.Builder
+				var emit func(OutputEvent)
+				if stream {
+					emit = func(e OutputEvent) {
+						if e.Stream == StreamStdout {
+							streamed.WriteString(e.Data)
+						}
+					}
+				}
+				res, err := s.RunStream(context.Background(), ExecuteRequest{Language: "python", Code: "print()"}, emit)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if res.Status != tt.status {
+					t.Fatalf("got status %q, want %q", res.Status, tt.status)
+				}
+				if res.Output != tt.output || res.Error != tt.error {
+					t.Fatalf("got %d bytes of output and %d of error, want %d and %d", len(res.Output), len(res.Error), len(tt.output), len(tt.error))
+				}
+				if truncated := tt.status == StatusOutputLimitExceeded; res.Truncated != truncated {
+					t.Fatalf("got truncated %t, want %t", res.Truncated, truncated)
+				}
+				if stream && streamed.String() != tt.output {
+					t.Fatalf("streamed %d bytes, want %d", streamed.Len(), len(tt.output))
+				}
+			})
+		}
+	}
+}
+
+// The kill is the cancellation of the phase's context with errOutputLimit
+// as its cause, which tells it apart from a timeout.
+func TestOutputLimitCause(t *testing.T) {
+	ctx, kill := context.WithCancelCause(context.Background())
+	defer kill(nil)
+
+	b := &limitedBuffer{max: 3, exceeded: func() { kill(errOutputLimit) }}
+	b.Write([]byte("abc"))
+	if ctx.Err() != nil {
+		t.Fatal("killed at the limit")
+	}
+	b.Write([]byte("d"))
+	if !errors.Is(context.Cause(ctx), errOutputLimit) {
+		t.Fatalf("got cause %v, want %v", context.Cause(ctx), errOutputLimit)
+	}
+}
@@ -0,0 +1,327 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrPoolFull = errors.New("execution queue is full")
+
+// Weights gives selected users more turns when the pool is contended. A user
+// with weight 3 gets up to three slots in a row before the next user waiting
+// is served. Users that are not listed have weight 1.
+type Weights map[int64]int
+
+func (w Weights) Get(userID int64) int {
+	if weight, ok := w[userID]; ok && weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// ParseWeights reads weights in the form "userID:weight,userID:weight".
+func ParseWeights(s string) (Weights, error) {
+	weights := make(Weights)
+	if strings.TrimSpace(s) == "" {
+		return weights, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		idStr, weightStr, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid weight %q: must be userID:weight", pair)
+		}
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight %q: user id must be an integer", pair)
+		}
+		weight, err := strconv.Atoi(weightStr)
+		if err != nil || weight < 1 {
+			return nil, fmt.Errorf("invalid weight %q: weight must be a positive integer", pair)
+		}
+		weights[id] = weight
+	}
+	return weights, nil
+}
+
+// Pool limits how many executions the wrapped Runner performs at once. Up to
+// maxQueue further callers wait for a free slot; anyone beyond that is
+// rejected straight away with ErrPoolFull.
+//
+// Waiting callers are grouped into one lane per ExecuteRequest.UserID and
+// lanes are served in weighted round-robin order, so a single user with a
+// burst of runs cannot starve everybody else.
+type Pool struct {
+	runner        Runner
+	weights       Weights
+	maxConcurrent int
+	maxQueue      int
+
+	mu     sync.Mutex
+	active int
+	queued int
+	lanes  map[int64]*lane
+	ring   []int64
+
+	rejected  int64
+	completed int64
+	waitCount int64
+	waitTotal time.Duration
+	waitMax   time.Duration
+}
+
+type lane struct {
+	waiters []*waiter
+	turns   int
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+	start   time.Time
+}
+
+type PoolStats struct {
+	Active          int   `json:"active"`
+	Queued          int   `json:"queued"`
+	UsersWaiting    int   `json:"users_waiting"`
+	MaxConcurrent   int   `json:"max_concurrent"`
+	MaxQueue        int   `json:"max_queue"`
+	Completed       int64 `json:"completed_total"`
+	Rejected        int64 `json:"rejected_total"`
+	WaitTotalMicros int64 `json:"wait_time_total_us"`
+	WaitMaxMicros   int64 `json:"wait_time_max_us"`
+	WaitCount       int64 `json:"wait_count"`
+}
+
+func NewPool(r Runner, maxConcurrent, maxQueue int, weights Weights) *Pool {
+	return &Pool{
+		runner:        r,
+		weights:       weights,
+		maxConcurrent: max(maxConcurrent, 1),
+		maxQueue:      maxQueue,
+		lanes:         make(map[int64]*lane),
+	}
+}
+
+func (p *Pool) Run(ctx context.Context, req ExecuteRequest) (*ExecuteResult, error) {
+	err := p.acquire(ctx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer p.release()
+
+	return p.runner.Run(ctx, req)
+}
+
+// RunBatch holds one execution slot for all of the inputs.
+func (p *Pool) RunBatch(ctx context.Context, req ExecuteRequest, inputs []RunInput) ([]*ExecuteResult, error) {
+	err := p.acquire(ctx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer p.release()
+
+	return RunBatch(ctx, p.runner, req, inputs)
+}
+
+// Start holds an execution slot for the whole interactive session.
+func (p *Pool) Start(ctx context.Context, req ExecuteRequest, limits SessionLimits, size TerminalSize) (*Session, error) {
+	ir, ok := p.runner.(InteractiveRunner)
+	if !ok {
+		return nil, ErrInteractiveUnsupported
+	}
+
+	err := p.acquire(ctx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := ir.Start(ctx, req, limits, size)
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+	go func() {
+		<-sess.Done()
+		p.release()
+	}()
+	return sess, nil
+}
+
+// StartREPL holds an execution slot for the whole REPL session.
+func (p *Pool) StartREPL(ctx context.Context, req ExecuteRequest, total time.Duration) (*REPL, error) {
+	rr, ok := p.runner.(REPLRunner)
+	if !ok {
+		return nil, ErrInteractiveUnsupported
+	}
+
+	err := p.acquire(ctx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := rr.StartREPL(ctx, req, total)
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+	go func() {
+		<-r.Done()
+		p.release()
+	}()
+	return r, nil
+}
+
+// RunStream is Run for streaming. Without a StreamRunner underneath, the
+// output is only available in the result.
+func (p *Pool) RunStream(ctx context.Context, req ExecuteRequest, emit func(OutputEvent)) (*ExecuteResult, error) {
+	err := p.acquire(ctx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer p.release()
+
+	if sr, ok := p.runner.(StreamRunner); ok {
+		return sr.RunStream(ctx, req, emit)
+	}
+	return p.runner.Run(ctx, req)
+}
+
+func (p *Pool) acquire(ctx context.Context, userID int64) error {
+	p.mu.Lock()
+
+	if p.active < p.maxConcurrent && p.queued == 0 {
+		p.active++
+		p.mu.Unlock()
+		return nil
+	}
+
+	if p.queued >= p.maxQueue {
+		p.rejected++
+		p.mu.Unlock()
+		return ErrPoolFull
+	}
+
+	w := &waiter{ready: make(chan struct{}), start: time.Now()}
+
+	l, ok := p.lanes[userID]
+	if !ok {
+		l = &lane{turns: p.weights.Get(userID)}
+		p.lanes[userID] = l
+		p.ring = append(p.ring, userID)
+	}
+	l.waiters = append(l.waiters, w)
+	p.queued++
+
+	p.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if w.granted {
+			// We were handed a slot just as ctx expired, pass it on.
+			p.active--
+			p.dispatch()
+		} else {
+			p.removeWaiter(userID, w)
+		}
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.active--
+	p.completed++
+	p.dispatch()
+}
+
+// dispatch hands free slots to waiters, one lane at a time. A lane keeps the
+// head of the ring until it has used up its weight, then moves to the back.
+// It must be called with p.mu held.
+func (p *Pool) dispatch() {
+	for p.active < p.maxConcurrent && len(p.ring) > 0 {
+		userID := p.ring[0]
+		l := p.lanes[userID]
+
+		w := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		l.turns--
+		p.queued--
+
+		p.active++
+		w.granted = true
+		p.recordWait(time.Since(w.start))
+		close(w.ready)
+
+		switch {
+		case len(l.waiters) == 0:
+			delete(p.lanes, userID)
+			p.ring = p.ring[1:]
+		case l.turns == 0:
+			l.turns = p.weights.Get(userID)
+			p.ring = append(p.ring[1:], userID)
+		}
+	}
+}
+
+// removeWaiter drops a waiter whose context ended before it got a slot.
+// It must be called with p.mu held.
+func (p *Pool) removeWaiter(userID int64, w *waiter) {
+	l := p.lanes[userID]
+	for i, candidate := range l.waiters {
+		if candidate == w {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			p.queued--
+			break
+		}
+	}
+	if len(l.waiters) > 0 {
+		return
+	}
+
+	delete(p.lanes, userID)
+	for i, id := range p.ring {
+		if id == userID {
+			p.ring = append(p.ring[:i], p.ring[i+1:]...)
+			break
+		}
+	}
+}
+
+func (p *Pool) recordWait(d time.Duration) {
+	p.waitCount++
+	p.waitTotal += d
+	p.waitMax = max(p.waitMax, d)
+}
+
+// Stats returns a snapshot of the pool's counters, suitable for expvar.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return PoolStats{
+		Active:          p.active,
+		Queued:          p.queued,
+		UsersWaiting:    len(p.ring),
+		MaxConcurrent:   p.maxConcurrent,
+		MaxQueue:        p.maxQueue,
+		Completed:       p.completed,
+		Rejected:        p.rejected,
+		WaitTotalMicros: p.waitTotal.Microseconds(),
+		WaitMaxMicros:   p.waitMax.Microseconds(),
+		WaitCount:       p.waitCount,
+	}
+}
@@ -0,0 +1,185 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitQueued waits until n callers wait for a slot of p.
+func waitQueued(t *testing.T, p *Pool, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Stats().Queued != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d callers queued, want %d", p.Stats().Queued, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPoolWeightedOrder(t *testing.T) {
+	p := NewPool(nil, 1, 10, Weights{1: 2})
+	if err := p.acquire(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each caller notes its user once it has a slot and hands it on.
+	order := make(chan int64, 5)
+	for i, userID := range []int64{1, 1, 1, 2, 2} {
+		go func() {
+			if err := p.acquire(context.Background(), userID); err != nil {
+				t.Error(err)
+				return
+			}
+			order <- userID
+			p.release()
+		}()
+		waitQueued(t, p, i+1)
+	}
+	p.release()
+
+	want := []int64{1, 1, 2, 1, 2}
+	for i, userID := range want {
+		select {
+		case got := <-order:
+			if got != userID {
+				t.Fatalf("slot %d went to user %d, want user %d (order %v)", i, got, userID, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("slot %d was never handed out", i)
+		}
+	}
+}
+
+func TestPoolCancelQueued(t *testing.T) {
+	p := NewPool(nil, 1, 10, nil)
+	if err := p.acquire(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() { errc <- p.acquire(ctx, 1) }()
+	waitQueued(t, p, 1)
+	cancel()
+
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if stats := p.Stats(); stats.Queued != 0 || stats.UsersWaiting != 0 || stats.Active != 1 {
+		t.Fatalf("got %+v after the cancelled caller left, want only the slot in use", stats)
+	}
+
+	p.release()
+	if err := p.acquire(context.Background(), 1); err != nil {
+		t.Fatalf("got error %v for a free slot", err)
+	}
+}
+
+// TestPoolCancelGranted hands a slot to a caller whose context has just
+// ended, but who has not yet taken itself out of the queue. The slot must go
+// on to the next caller rather than leak.
+func TestPoolCancelGranted(t *testing.T) {
+	p := NewPool(nil, 1, 10, nil)
+	if err := p.acquire(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() { first <- p.acquire(ctx, 1) }()
+	waitQueued(t, p, 1)
+	next := make(chan error)
+	go func() { next <- p.acquire(context.Background(), 2) }()
+	waitQueued(t, p, 2)
+
+	// The first caller wakes up to its cancelled context, then waits for
+	// the lock while the slot is released to it.
+	p.mu.Lock()
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	p.active--
+	p.dispatch()
+	p.mu.Unlock()
+
+	// On a very busy machine the caller may only get to run once it has the
+	// slot, and then it keeps it.
+	switch err := <-first; {
+	case err == nil:
+		p.release()
+	case !errors.Is(err, context.Canceled):
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	select {
+	case err := <-next:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slot was never handed on")
+	}
+	p.release()
+
+	if stats := p.Stats(); stats.Active != 0 || stats.Queued != 0 {
+		t.Fatalf("got %+v after everybody left, want an idle pool", stats)
+	}
+}
+
+func TestPoolFull(t *testing.T) {
+	p := NewPool(nil, 1, 1, nil)
+	if err := p.acquire(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.acquire(ctx, 1)
+	waitQueued(t, p, 1)
+
+	if err := p.acquire(context.Background(), 2); !errors.Is(err, ErrPoolFull) {
+		t.Fatalf("got error %v, want %v", err, ErrPoolFull)
+	}
+	if rejected := p.Stats().Rejected; rejected != 1 {
+		t.Errorf("got %d rejected, want 1", rejected)
+	}
+}
+
+// replRunner starts REPLs that end when their done channel is closed.
+type replRunner struct {
+	Runner
+	started []ExecuteRequest
+}
+
+func (r *replRunner) StartREPL(ctx context.Context, req ExecuteRequest, total time.Duration) (*REPL, error) {
+	r.started = append(r.started, req)
+	return &REPL{done: make(chan struct{})}, nil
+}
+
+func TestPoolREPLHoldsSlot(t *testing.T) {
+	rr := &replRunner{}
+	p := NewPool(rr, 1, 0, nil)
+
+	req := ExecuteRequest{UserID: 1, Language: "python", Version: "3.12"}
+	r, err := p.StartREPL(context.Background(), req, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rr.started) != 1 || rr.started[0].Version != "3.12" {
+		t.Fatalf("started %+v, want the request passed on", rr.started)
+	}
+	if _, err := p.StartREPL(context.Background(), req, time.Minute); !errors.Is(err, ErrPoolFull) {
+		t.Fatalf("got error %v while the session holds the slot, want %v", err, ErrPoolFull)
+	}
+
+	close(r.done)
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Stats().Active != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the session's slot was never released")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,576 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The drivers speak JSON lines. Each cell goes to the driver's stdin as
+// {"code": "..."} and the driver answers with a frame: a line starting with
+// replMarker, followed by the JSON of a replReply. Every frame starts on a
+// fresh line, so the driver writes a newline in front of it. Anything else
+// on stdout or stderr is output the cell wrote around the interpreter's
+// capture, such as that of a child process. The first frame only reports
+// that the driver is ready.
+//
+//go:embed repl
+var replFS embed.FS
+
+var replDriverFiles = map[string]string{
+	DriverPython: "repl/python.py",
+	DriverRuby:   "repl/ruby.rb",
+	DriverNode:   "repl/node.js",
+}
+
+var replMarker = []byte("\x1eREPL ")
+
+// replStartTimeout bounds how long an interpreter may take to start.
+const replStartTimeout = 30 * time.Second
+
+var (
+	ErrREPLNotFound = errors.New("repl session not found")
+	ErrREPLBusy     = errors.New("repl session is still evaluating a cell")
+	ErrREPLLimit    = errors.New("too many repl sessions for this user")
+	ErrREPLFull     = errors.New("too many repl sessions")
+
+	errREPLEnded = errors.New("repl session has ended")
+)
+
+type replReply struct {
+	Ready     bool   `json:"ready"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	Error     string `json:"error"`
+	Value     string `json:"value"`
+	Truncated bool   `json:"truncated"`
+}
+
+// EvalResult is the outcome of one cell of a REPL session. Value is the
+// representation of the cell's last expression, as an interactive
+// interpreter would echo it. Ended reports that the interpreter is gone,
+// because the cell exited it, timed out or ran out of memory, and the
+// session with it.
+type EvalResult struct {
+	Status     Status `json:"status"`
+	Output     string `json:"output"`
+	Error      string `json:"error"`
+	Value      string `json:"value,omitempty"`
+	WallTimeMS int64  `json:"wall_time_ms"`
+	Truncated  bool   `json:"truncated"`
+	Ended      bool   `json:"session_ended"`
+}
+
+// REPLRunner starts interpreters that keep their state between cells.
+type REPLRunner interface {
+	StartREPL(ctx context.Context, req ExecuteRequest, total time.Duration) (*REPL, error)
+}
+
+// REPL is a running interpreter. It evaluates one cell at a time.
+type REPL struct {
+	input  *os.File
+	output *os.File
+	reader *bufio.Reader
+	// timeout bounds each cell.
+	timeout time.Duration
+	cancel  context.CancelFunc
+	// clean is applied to everything a cell returns.
+	clean OutputOptions
+	// limit caps what the driver captures of each stream, the value and
+	// the error. A reply frame holds them escaped as JSON, so it may be
+	// up to 16 times larger.
+	limit int
+
+	mu sync.Mutex
+
+	done   chan struct{}
+	result *ExecuteResult
+	err    error
+}
+
+// StartREPL starts the REPL driver of the language and version of req in a
+// container of its own and waits until it is ready. The container has the
+// limits of req, whose zero fields take the language's run limits, and their
+// timeout bounds each cell. The container may live for total, and ends early
+// when ctx does.
+func (s *sandbox) StartREPL(ctx context.Context, req ExecuteRequest, total time.Duration) (*REPL, error) {
+	if _, ok := s.engine.(*cliEngine); !ok {
+		return nil, ErrInteractiveUnsupported
+	}
+	lang, ok := s.languages.Get(req.Language)
+	if !ok {
+		return nil, fmt.Errorf("unsupported language: %s", req.Language)
+	}
+	lang, ok = lang.WithVersion(req.Version)
+	if !ok {
+		return nil, fmt.Errorf("unsupported version %s of %s", req.Version, req.Language)
+	}
+	if lang.REPL == nil {
+		return nil, fmt.Errorf("language %s has no repl", lang.ID)
+	}
+
+	driver, err := replFS.ReadFile(replDriverFiles[lang.REPL.Driver])
+	if err != nil {
+		return nil, err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "runner-*")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create temp dir: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
+
+	driverFile := filepath.Join(tmpDir, "repl")
+	if err := os.Mkdir(filepath.Join(tmpDir, "app"), 0o777); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to create app dir: %w", err)
+	}
+	if err := os.WriteFile(driverFile, driver, 0o644); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to write repl driver: %w", err)
+	}
+	if err := os.Chmod(driverFile, 0o644); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to write repl driver: %w", err)
+	}
+
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		inR.Close()
+		inW.Close()
+		cleanup()
+		return nil, err
+	}
+
+	limits := req.Limits.withDefaults(lang.RunLimits)
+	timeout := time.Duration(limits.Timeout)
+	limits.Timeout = Duration(total)
+
+	replCtx, cancel := context.WithCancel(ctx)
+	r := &REPL{
+		input:   inW,
+		output:  outR,
+		reader:  bufio.NewReaderSize(outR, 64*1024),
+		timeout: timeout,
+		cancel:  cancel,
+		clean:   s.output,
+		limit:   max(s.output.StdoutBytes, s.output.StderrBytes),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer cleanup()
+		defer cancel()
+
+		result, err := s.runPhase(replCtx, tmpDir, phase{
+			image:   lang.Image,
+			command: lang.REPL.Command,
+			limits:  limits,
+			env:     map[string]string{"REPL_OUTPUT_LIMIT": strconv.Itoa(r.limit)},
+			mounts:  []mount{{source: driverFile, target: replMountPath, readOnly: true}},
+			input:   inR,
+			output:  outW,
+		})
+		// With our ends of the pipes gone too, the driver's output ends in
+		// EOF and writing more cells fails.
+		inR.Close()
+		outW.Close()
+
+		r.result, r.err = result, err
+		close(r.done)
+	}()
+
+	r.output.SetReadDeadline(time.Now().Add(replStartTimeout))
+	raw := &limitedBuffer{max: r.clean.StdoutBytes}
+	reply, err := r.read(raw)
+	if err == nil && !reply.Ready {
+		err = errors.New("driver sent a result before it was ready")
+	}
+	if err != nil {
+		r.Close()
+		if r.err == nil && r.result != nil && r.result.Status == StatusInternalError {
+			err = fmt.Errorf("%w: %s", err, r.result.Error)
+		}
+		return nil, fmt.Errorf("repl did not start: %w: %s", err, bytes.TrimSpace(raw.buf.Bytes()))
+	}
+	return r, nil
+}
+
+// Eval runs code in the interpreter. A cell that runs longer than the
+// language's run timeout ends the session, since the interpreter cannot be
+// trusted to be in a usable state any more. Evaluating a cell while another
+// one still runs fails with ErrREPLBusy.
+func (r *REPL) Eval(code string) (*EvalResult, error) {
+	if !r.mu.TryLock() {
+		return nil, ErrREPLBusy
+	}
+	defer r.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return nil, errREPLEnded
+	default:
+	}
+
+	cell, err := json.Marshal(map[string]string{"code": code})
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	deadline := start.Add(r.timeout)
+	r.input.SetWriteDeadline(deadline)
+	r.output.SetReadDeadline(deadline)
+
+	raw := &limitedBuffer{max: r.clean.StdoutBytes}
+	var reply *replReply
+	_, err = r.input.Write(append(cell, '\n'))
+	if err == nil {
+		reply, err = r.read(raw)
+	}
+	elapsed := time.Since(start)
+
+	if err == nil {
+		result := &EvalResult{
+			Status:     StatusOK,
+			Output:     r.clean.sanitize(raw.String() + reply.Stdout),
+			Error:      r.clean.sanitize(reply.Stderr + reply.Error),
+			Value:      r.clean.sanitize(reply.Value),
+			WallTimeMS: elapsed.Milliseconds(),
+			Truncated:  reply.Truncated || raw.truncated,
+		}
+		if reply.Error != "" {
+			result.Status = StatusRuntimeError
+		}
+		return result, nil
+	}
+
+	// The cell ended the interpreter one way or another.
+	r.cancel()
+	<-r.done
+
+	result := &EvalResult{
+		Output:     r.clean.sanitize(raw.String()),
+		WallTimeMS: elapsed.Milliseconds(),
+		Truncated:  raw.truncated,
+		Ended:      true,
+	}
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		result.Status = StatusTimeout
+		result.Error = "Execution timed out"
+	case errors.Is(err, errFrameTooLong):
+		result.Status = StatusRuntimeError
+		result.Error = "Session ended: the cell's result was too large"
+	case r.err != nil:
+		return nil, r.err
+	case r.result.Status == StatusOK || r.result.Status == StatusRuntimeError:
+		result.Status = StatusRuntimeError
+		result.Error = fmt.Sprintf("Session ended: the interpreter exited with code %d", r.result.ExitCode)
+	default:
+		result.Status = r.result.Status
+		result.Error = r.result.Error
+	}
+	return result, nil
+}
+
+var errFrameTooLong = errors.New("repl frame too long")
+
+// read reads the output of the current cell into raw up to its reply
+// frame, and returns the reply.
+func (r *REPL) read(raw *limitedBuffer) (*replReply, error) {
+	var frame []byte
+	inFrame, lineStart := false, true
+
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		if lineStart && bytes.HasPrefix(chunk, replMarker) {
+			inFrame = true
+		}
+		if inFrame {
+			if len(frame)+len(chunk) > 16*r.limit {
+				return nil, errFrameTooLong
+			}
+			frame = append(frame, chunk...)
+		} else {
+			raw.Write(chunk)
+		}
+
+		switch {
+		case err == nil && inFrame:
+			var reply replReply
+			err = json.Unmarshal(frame[len(replMarker):], &reply)
+			if err != nil {
+				return nil, fmt.Errorf("invalid repl frame: %w", err)
+			}
+			// Drop the newline the driver wrote in front of the frame.
+			if b := raw.buf.Bytes(); len(b) > 0 && b[len(b)-1] == '\n' {
+				raw.buf.Truncate(len(b) - 1)
+			}
+			return &reply, nil
+		case err == nil:
+			lineStart = true
+		case errors.Is(err, bufio.ErrBufferFull):
+			lineStart = false
+		default:
+			return nil, err
+		}
+	}
+}
+
+// Done is closed once the interpreter has exited and its container is gone.
+func (r *REPL) Done() <-chan struct{} {
+	return r.done
+}
+
+// Close kills the interpreter and waits until its container is gone.
+func (r *REPL) Close() {
+	r.cancel()
+	<-r.done
+	r.input.Close()
+	r.output.Close()
+}
+
+// REPLLimits bounds the REPL sessions of a REPLManager.
+type REPLLimits struct {
+	// Idle ends sessions that evaluated nothing for that long.
+	Idle time.Duration
+	// Total ends sessions this long after they started, no matter what.
+	Total time.Duration
+	// PerUser and Max cap the open sessions of a single user and of the
+	// whole manager.
+	PerUser int
+	Max     int
+}
+
+var DefaultREPLLimits = REPLLimits{Idle: 10 * time.Minute, Total: time.Hour, PerUser: 2, Max: 32}
+
+// REPLSession describes a session to its owner.
+type REPLSession struct {
+	ID        string    `json:"id"`
+	Language  string    `json:"language"`
+	Version   string    `json:"language_version"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type replEntry struct {
+	REPLSession
+	userID   int64
+	repl     *REPL
+	lastUsed time.Time
+}
+
+// REPLManager keeps the REPL sessions of all users. Sessions live in this
+// process only, so a load balancer has to send every request for a session
+// to the instance that created it. Given a Pool, every session holds one of
+// its slots until it ends.
+type REPLManager struct {
+	runner REPLRunner
+	limits REPLLimits
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	sessions map[string]*replEntry
+}
+
+func NewREPLManager(r REPLRunner, limits REPLLimits) *REPLManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &REPLManager{
+		runner:   r,
+		limits:   limits,
+		ctx:      ctx,
+		cancel:   cancel,
+		sessions: make(map[string]*replEntry),
+	}
+	go m.evictIdle()
+	return m
+}
+
+// Create starts a session for the user of req whose interpreter runs with its
+// language, version and limits. Starting it takes a while, the session counts
+// against the manager's limits from the start. ctx only bounds the start,
+// including the wait for a Pool slot.
+func (m *REPLManager) Create(ctx context.Context, req ExecuteRequest) (*REPLSession, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	entry := &replEntry{
+		REPLSession: REPLSession{
+			ID:        hex.EncodeToString(b),
+			Language:  req.Language,
+			Version:   req.Version,
+			CreatedAt: now,
+			ExpiresAt: now.Add(m.limits.Total),
+		},
+		userID:   req.UserID,
+		lastUsed: now,
+	}
+
+	m.mu.Lock()
+	if len(m.sessions) >= m.limits.Max {
+		m.mu.Unlock()
+		return nil, ErrREPLFull
+	}
+	count := 0
+	for _, e := range m.sessions {
+		if e.userID == req.UserID {
+			count++
+		}
+	}
+	if count >= m.limits.PerUser {
+		m.mu.Unlock()
+		return nil, ErrREPLLimit
+	}
+	m.sessions[entry.ID] = entry
+	m.mu.Unlock()
+
+	// The session outlives ctx, but not while it is starting.
+	replCtx, cancel := context.WithCancel(m.ctx)
+	stop := context.AfterFunc(ctx, cancel)
+	r, err := m.runner.StartREPL(replCtx, req, m.limits.Total)
+	if err != nil || !stop() {
+		if err == nil {
+			r.Close()
+			err = ctx.Err()
+		}
+		cancel()
+		m.remove(entry.ID)
+		return nil, err
+	}
+
+	m.mu.Lock()
+	entry.repl = r
+	m.mu.Unlock()
+
+	go func() {
+		<-r.Done()
+		cancel()
+		m.remove(entry.ID)
+	}()
+
+	session := entry.REPLSession
+	return &session, nil
+}
+
+// Eval runs code in the session id of userID. Sessions of other users are
+// reported as not found.
+func (m *REPLManager) Eval(userID int64, id, code string) (*EvalResult, error) {
+	r, err := m.get(userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := r.Eval(code)
+	m.touch(id)
+	if errors.Is(err, errREPLEnded) {
+		return nil, ErrREPLNotFound
+	}
+	return result, err
+}
+
+// Delete ends the session id of userID.
+func (m *REPLManager) Delete(userID int64, id string) error {
+	r, err := m.get(userID, id)
+	if err != nil {
+		return err
+	}
+	m.remove(id)
+	r.Close()
+	return nil
+}
+
+// Close ends every session.
+func (m *REPLManager) Close() {
+	m.cancel()
+
+	m.mu.Lock()
+	var repls []*REPL
+	for _, e := range m.sessions {
+		if e.repl != nil {
+			repls = append(repls, e.repl)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, r := range repls {
+		r.Close()
+	}
+}
+
+func (m *REPLManager) get(userID int64, id string) (*REPL, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.sessions[id]
+	if !ok || e.userID != userID || e.repl == nil {
+		return nil, ErrREPLNotFound
+	}
+	e.lastUsed = time.Now()
+	return e.repl, nil
+}
+
+func (m *REPLManager) touch(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.sessions[id]; ok {
+		e.lastUsed = time.Now()
+	}
+}
+
+func (m *REPLManager) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+}
+
+func (m *REPLManager) evictIdle() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		m.mu.Lock()
+		var idle []*replEntry
+		for _, e := range m.sessions {
+			if e.repl != nil && time.Since(e.lastUsed) > m.limits.Idle {
+				idle = append(idle, e)
+				delete(m.sessions, e.ID)
+			}
+		}
+		m.mu.Unlock()
+
+		for _, e := range idle {
+			e.repl.Close()
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// REPL driver for Node.js. It reads one JSON cell per line from stdin, runs
+// it as a script in the global context, which keeps its variables between
+// cells, and writes one result frame per cell, see repl.go for the protocol.
+"use strict";
+
+const { Console } = require("console");
+const { createRequire } = require("module");
+const readline = require("readline");
+const { Writable } = require("stream");
+const util = require("util");
+const vm = require("vm");
+
+const MARKER = "\x1eREPL ";
+const LIMIT = Number(process.env.REPL_OUTPUT_LIMIT || 1048576);
+
+// Capture is written to synchronously, as it calls back straight away.
+class Capture extends Writable {
+  constructor() {
+    super({ decodeStrings: false });
+    this.text = "";
+    this.truncated = false;
+  }
+
+  _write(chunk, encoding, callback) {
+    let s = String(chunk);
+    const room = LIMIT - this.text.length;
+    if (s.length > room) {
+      this.truncated = true;
+      s = s.slice(0, Math.max(room, 0));
+    }
+    this.text += s;
+    callback();
+  }
+}
+
+// Writes to a pipe are synchronous on Linux, so frames never interleave
+// with output the code writes to process.stdout itself.
+function send(frame) {
+  process.stdout.write("\n" + MARKER + JSON.stringify(frame) + "\n");
+}
+
+function formatError(err) {
+  if (!(err instanceof Error) || !err.stack) {
+    return "Uncaught " + util.inspect(err);
+  }
+  // Frames below the cell belong to vm and this driver.
+  const lines = err.stack.split("\n");
+  const end = lines.findIndex((line) => line.includes("node:vm") || line.includes(__filename));
+  return (end < 0 ? lines : lines.slice(0, end)).join("\n") + "\n";
+}
+
+globalThis.require = createRequire("/app/");
+
+async function evaluate(code, out, err) {
+  globalThis.console = new Console({ stdout: out, stderr: err });
+  let value = vm.runInThisContext(code, { filename: "cell" });
+  if (value && typeof value.then === "function") {
+    value = await value;
+  }
+  return value === undefined ? "" : util.inspect(value).slice(0, LIMIT);
+}
+
+async function main() {
+  send({ ready: true });
+  const lines = readline.createInterface({ input: process.stdin, crlfDelay: Infinity });
+  for await (const line of lines) {
+    const { code } = JSON.parse(line);
+    const out = new Capture();
+    const err = new Capture();
+    let value = "";
+    let error = "";
+    try {
+      value = await evaluate(code, out, err);
+    } catch (e) {
+      error = formatError(e).slice(0, LIMIT);
+    }
+    send({
+      stdout: out.text,
+      stderr: err.text,
+      error: error,
+      value: value,
+      truncated: out.truncated || err.truncated,
+    });
+  }
+}
+
+main();
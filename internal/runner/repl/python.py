@@ -0,0 +1,90 @@
+# REPL driver for Python. It reads one JSON cell per line from stdin, runs
+# it in a namespace that lives as long as the process and writes one result
+# frame per cell, see repl.go for the protocol.
+import ast
+import contextlib
+import io
+import json
+import os
+import sys
+import traceback
+
+MARKER = "\x1eREPL "
+LIMIT = int(os.environ.get("REPL_OUTPUT_LIMIT", "1048576"))
+
+
+class Capture(io.TextIOBase):
+    def __init__(self):
+        self.parts = []
+        self.size = 0
+        self.truncated = False
+
+    def writable(self):
+        return True
+
+    def write(self, s):
+        n = len(s)
+        room = LIMIT - self.size
+        if n > room:
+            self.truncated = True
+            s = s[: max(room, 0)]
+        self.parts.append(s)
+        self.size += len(s)
+        return n
+
+    def getvalue(self):
+        return "".join(self.parts)
+
+
+# The protocol keeps its own copies of stdin and stdout, user code reads an
+# empty stdin and whatever it writes to the real stdout ends up in the cell's
+# output anyway.
+proto_in = os.fdopen(os.dup(0), "r", encoding="utf-8")
+proto_out = os.fdopen(os.dup(1), "w", encoding="utf-8")
+os.dup2(os.open(os.devnull, os.O_RDONLY), 0)
+sys.stdin = io.StringIO()
+
+namespace = {"__name__": "__main__", "__builtins__": __builtins__}
+
+
+def send(frame):
+    sys.__stdout__.flush()
+    proto_out.write("\n" + MARKER + json.dumps(frame) + "\n")
+    proto_out.flush()
+
+
+def run(code):
+    tree = ast.parse(code, "<cell>", "exec")
+    last = None
+    if tree.body and isinstance(tree.body[-1], ast.Expr):
+        last = ast.Expression(tree.body.pop().value)
+    exec(compile(tree, "<cell>", "exec"), namespace)
+    if last is not None:
+        value = eval(compile(last, "<cell>", "eval"), namespace)
+        if value is not None:
+            return repr(value)[:LIMIT]
+    return ""
+
+
+send({"ready": True})
+for line in proto_in:
+    code = json.loads(line)["code"]
+    out, err = Capture(), Capture()
+    value, error = "", ""
+    with contextlib.redirect_stdout(out), contextlib.redirect_stderr(err):
+        try:
+            value = run(code)
+        except SyntaxError as e:
+            error = "".join(traceback.format_exception_only(type(e), e))
+        except Exception as e:
+            tb = e.__traceback__
+            while tb is not None and tb.tb_frame.f_code.co_filename != "<cell>":
+                tb = tb.tb_next
+            error = "".join(traceback.format_exception(type(e), e, tb))
+    send({
+        "stdout": out.getvalue(),
+        "stderr": err.getvalue(),
+        "error": error[:LIMIT],
+        "value": value,
+        "truncated": out.truncated or err.truncated,
+    })
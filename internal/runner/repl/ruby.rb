@@ -0,0 +1,90 @@
+# REPL driver for Ruby. It reads one JSON cell per line from stdin, evaluates
+# it in a binding that keeps its local variables between cells and writes
+# one result frame per cell, see repl.go for the protocol.
+require "json"
+require "stringio"
+
+MARKER = "\x1eREPL "
+LIMIT = Integer(ENV.fetch("REPL_OUTPUT_LIMIT", "1048576"))
+
+class Capture < StringIO
+  attr_reader :truncated
+
+  def write(*args)
+    n = 0
+    args.each do |arg|
+      s = arg.to_s
+      n += s.bytesize
+      room = LIMIT - size
+      if s.bytesize > room
+        @truncated = true
+        s = s.byteslice(0, [room, 0].max)
+      end
+      super(s)
+    end
+    n
+  end
+
+  def text
+    string.dup.force_encoding(Encoding::UTF_8).scrub
+  end
+end
+
+# A method's binding keeps the driver's own variables out of the cells.
+def repl_binding
+  binding
+end
+
+def send_frame(out, frame)
+  STDOUT.flush
+  out.write("\n" + MARKER + JSON.generate(frame) + "\n")
+end
+
+# Formats e the way ruby reports uncaught exceptions, without the frames of
+# this driver.
+def format_error(e)
+  frames = (e.backtrace || []).take_while { |line| line.start_with?("(cell)") }
+  message = "#{e.message} (#{e.class})"
+  message = "#{frames.first}: #{message}" unless frames.empty?
+  ([message] + frames.drop(1).map { |line| "\tfrom #{line}" }).join("\n") + "\n"
+end
+
+def main
+  # The protocol keeps its own copies of stdin and stdout, user code reads
+  # an empty stdin and whatever it writes to the real stdout ends up in the
+  # cell's output anyway.
+  proto_in = STDIN.dup
+  proto_in.set_encoding(Encoding::UTF_8)
+  proto_out = STDOUT.dup
+  proto_out.sync = true
+  STDIN.reopen(File::NULL)
+  workspace = repl_binding
+
+  send_frame(proto_out, ready: true)
+  proto_in.each_line do |line|
+    code = JSON.parse(line)["code"]
+    out = Capture.new
+    err = Capture.new
+    value = ""
+    error = ""
+    $stdout, $stderr = out, err
+    begin
+      result = workspace.eval(code, "(cell)", 1)
+      value = result.inspect[0, LIMIT] unless result.nil?
+    rescue SystemExit, SignalException
+      raise
+    rescue Exception => e
+      error = format_error(e)[0, LIMIT]
+    ensure
+      $stdout, $stderr = STDOUT, STDERR
+    end
+    send_frame(proto_out,
+      stdout: out.text,
+      stderr: err.text,
+      error: error.scrub,
+      value: value.scrub,
+      truncated: out.truncated || err.truncated || false)
+  end
+end
+
+main
@@ -1,12 +1,208 @@
 package runner
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/VJ-2303/code-runner/internal/validator"
+)
+
+const (
+	MaxStdinBytes    = 64 * 1024
+	MaxArgs          = 32
+	MaxArgBytes      = 1024
+	MaxEnvVars       = 32
+	MaxEnvValueBytes = 4096
+
+	// MinMemoryMB and MinCPUs are the smallest limits the container
+	// engines accept.
+	MinMemoryMB = 6
+	MinCPUs     = 0.01
+
+	DefaultTimeout = 10 * time.Second
+)
+
+var EnvKeyRX = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedEnv lists variables the sandbox relies on, which callers may not override.
+var reservedEnv = []string{"PATH", "HOME", "HOSTNAME"}
+
+type ExecuteRequest struct {
+	Code     string
+	Language string
+	// Version is the ID of one of the language's versions, or empty for
+	// its default.
+	Version string
+	// Files replaces Code for programs made of several files. EntryPoint
+	// is the path of the main one and defaults to the language's FileName.
+	Files      []File
+	EntryPoint string
+	Stdin      []byte
+	Args       []string
+	Env        map[string]string
+	// UserID identifies who asked for the run, so that Pool can share
+	// execution slots fairly between users.
+	UserID int64
+	// Limits bound the run phase, or the test framework of a test run.
+	// Compilation has its own limits configured per language. Zero fields
+	// take the language's run or test limits.
+	Limits Limits
+	// TestCode turns the run into a test run: it is stored as the
+	// language's test file and the test framework runs instead of the
+	// program, leaving its report in the result.
+	TestCode string
+}
+
+// Status describes how an execution ended. Only StatusInternalError points at
+// a problem with the sandbox itself, every other status is about the user's program.
+type Status string
+
+const (
+	StatusOK              Status = "ok"
+	StatusRuntimeError    Status = "runtime_error"
+	StatusTimeout         Status = "timeout"
+	StatusOOMKilled       Status = "oom_killed"
+	StatusCompileError    Status = "compile_error"
+	StatusDependencyError Status = "dependency_error"
+	// StatusOutputLimitExceeded is a program killed for writing more than
+	// the output limits allow.
+	StatusOutputLimitExceeded Status = "output_limit_exceeded"
+	// StatusTerminated ends interactive sessions the client closed.
+	StatusTerminated    Status = "terminated"
+	StatusInternalError Status = "internal_error"
+)
 
 type ExecuteResult struct {
-	Output string `json:"output"`
-	Error  string `json:"error"`
+	Status          Status `json:"status"`
+	Output          string `json:"output"`
+	Error           string `json:"error"`
+	ExitCode        int    `json:"exit_code"`
+	WallTimeMS      int64  `json:"wall_time_ms"`
+	CPUTimeMS       int64  `json:"cpu_time_ms"`
+	PeakMemoryBytes int64  `json:"peak_memory_bytes"`
+	Truncated       bool   `json:"truncated"`
+	// Limits are the limits the phase ran with.
+	Limits *Limits `json:"limits,omitempty"`
+
+	// Compile holds the compile phase for compiled languages. Compiler
+	// diagnostics live here, never in Output or Error.
+	Compile *ExecuteResult `json:"compile,omitempty"`
+	// Install holds the dependency install, when the run's manifest was
+	// not cached yet.
+	Install *ExecuteResult `json:"install,omitempty"`
+	// Report is what the test framework of a test run wrote.
+	Report string `json:"-"`
 }
 
 type Runner interface {
-	Run(ctx context.Context, code string, language string) (*ExecuteResult, error)
+	Run(ctx context.Context, req ExecuteRequest) (*ExecuteResult, error)
+}
+
+// RunInput is one input of a batch run. Its Stdin and Limits replace those of
+// the batch's request.
+type RunInput struct {
+	Stdin  []byte
+	Limits Limits
+}
+
+// BatchRunner is a Runner that runs one program with several inputs, and
+// installs its dependencies and compiles it only once for all of them. The
+// results come in the order of the inputs, except that a failed install or
+// compilation is a single result.
+type BatchRunner interface {
+	Runner
+	RunBatch(ctx context.Context, req ExecuteRequest, inputs []RunInput) ([]*ExecuteResult, error)
+}
+
+// RunBatch runs req with every input through r. Runners that are no
+// BatchRunner get one run per input, which stops after a failed install or
+// compilation.
+func RunBatch(ctx context.Context, r Runner, req ExecuteRequest, inputs []RunInput) ([]*ExecuteResult, error) {
+	if br, ok := r.(BatchRunner); ok {
+		return br.RunBatch(ctx, req, inputs)
+	}
+
+	results := make([]*ExecuteResult, 0, len(inputs))
+	for _, in := range inputs {
+		run := req
+		run.Stdin = in.Stdin
+		run.Limits = in.Limits
+
+		res, err := r.Run(ctx, run)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+		if res.Status == StatusCompileError || res.Status == StatusDependencyError {
+			break
+		}
+	}
+	return results, nil
+}
+
+// OutputEvent is a chunk of a program's output, as it was produced. Chunks
+// always hold whole UTF-8 sequences.
+type OutputEvent struct {
+	Stream string    `json:"stream"`
+	Data   string    `json:"data"`
+	Time   time.Time `json:"time"`
+}
+
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// StreamRunner is a Runner that also hands out the output of the run phase
+// while the program is still running. The result holds all of it again at
+// the end. emit may be called from several goroutines at once.
+type StreamRunner interface {
+	Runner
+	RunStream(ctx context.Context, req ExecuteRequest, emit func(OutputEvent)) (*ExecuteResult, error)
+}
+
+func ValidateExecuteRequest(v *validator.Validator, req ExecuteRequest) {
+	if len(req.Files) == 0 {
+		v.Check(req.Code != "", "code", "must be provided")
+	} else {
+		v.Check(req.Code == "", "code", "must not be provided together with files")
+		ValidateFiles(v, req.Files, req.EntryPoint)
+	}
+
+	v.Check(len(req.TestCode) <= MaxFileBytes, "test", "must not be more than 256KB")
+	v.Check(len(req.Stdin) <= MaxStdinBytes, "stdin", "must not be more than 64KB")
+
+	v.Check(len(req.Args) <= MaxArgs, "args", "must not contain more than 32 arguments")
+	for _, arg := range req.Args {
+		v.Check(len(arg) <= MaxArgBytes, "args", "each argument must not be more than 1024 bytes")
+		v.Check(!strings.ContainsRune(arg, 0), "args", "must not contain NUL bytes")
+	}
+
+	v.Check(len(req.Env) <= MaxEnvVars, "env", "must not contain more than 32 variables")
+	for key, value := range req.Env {
+		v.Check(validator.Matches(key, *EnvKeyRX), "env", "variable names must only contain letters, digits and underscores")
+		v.Check(!validator.PermittedValue(strings.ToUpper(key), reservedEnv...), "env", "must not override PATH, HOME or HOSTNAME")
+		v.Check(len(value) <= MaxEnvValueBytes, "env", "each value must not be more than 4096 bytes")
+		v.Check(!strings.ContainsRune(value, 0), "env", "must not contain NUL bytes")
+	}
+}
+
+// ValidateLimits checks the run limits a request asks for against caps. Zero
+// fields ask for the language's run limits.
+func ValidateLimits(v *validator.Validator, requested, caps Limits) {
+	v.Check(requested.Timeout >= 0, "time_limit_ms", "must not be negative")
+	v.Check(requested.Timeout <= caps.Timeout, "time_limit_ms", fmt.Sprintf("must not be more than %d", time.Duration(caps.Timeout).Milliseconds()))
+	v.Check(requested.MemoryMB == 0 || requested.MemoryMB >= MinMemoryMB, "memory_mb", fmt.Sprintf("must be at least %d", MinMemoryMB))
+	v.Check(requested.MemoryMB <= caps.MemoryMB, "memory_mb", fmt.Sprintf("must not be more than %d", caps.MemoryMB))
+	v.Check(requested.CPUs == 0 || requested.CPUs >= MinCPUs, "cpus", fmt.Sprintf("must be at least %g", MinCPUs))
+	v.Check(requested.CPUs <= caps.CPUs, "cpus", fmt.Sprintf("must not be more than %s", caps.dockerCPUs()))
+}
+
+// ValidateVersion checks that version, when set, is one lang offers.
+func ValidateVersion(v *validator.Validator, lang Language, version string) {
+	ids := lang.VersionIDs()
+	v.Check(version == "" || validator.PermittedValue(version, ids...), "language_version", "must be one of "+strings.Join(ids, ", "))
 }
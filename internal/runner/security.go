@@ -0,0 +1,123 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// SecurityProfile controls how tightly DockerRunner locks down a container
+// beyond the network, memory and CPU limits that always apply.
+type SecurityProfile struct {
+	// ReadOnlyRootfs mounts the image read-only. The /app work dir and
+	// /tmp are then size-limited tmpfs mounts.
+	ReadOnlyRootfs  bool
+	WorkDirSizeMB   int
+	TmpSizeMB       int
+	DropAllCaps     bool
+	NoNewPrivileges bool
+	// User is passed to --user, for example "65534:65534". Empty keeps
+	// the image's default user.
+	User        string
+	PidsLimit   int
+	NofileLimit int
+	FsizeMB     int
+	// SeccompProfile is the path of a seccomp JSON profile. Empty keeps
+	// Docker's default profile.
+	SeccompProfile string
+	// Runtime selects the OCI runtime, for example "runsc" for gVisor.
+	Runtime string
+}
+
+func DefaultSecurityProfile() SecurityProfile {
+	return SecurityProfile{
+		ReadOnlyRootfs:  true,
+		WorkDirSizeMB:   64,
+		TmpSizeMB:       256,
+		DropAllCaps:     true,
+		NoNewPrivileges: true,
+		User:            "65534:65534",
+		PidsLimit:       128,
+		NofileLimit:     256,
+		FsizeMB:         64,
+	}
+}
+
+func (sp SecurityProfile) Validate() error {
+	if sp.ReadOnlyRootfs && (sp.WorkDirSizeMB <= 0 || sp.TmpSizeMB <= 0) {
+		return errors.New("a read-only root filesystem needs tmpfs sizes for the work dir and /tmp")
+	}
+	if sp.User != "" && sp.WorkDirSizeMB <= 0 {
+		return errors.New("a sandbox user needs the work dir tmpfs, since images do not ship a writable /app")
+	}
+	if sp.WorkDirSizeMB < 0 || sp.TmpSizeMB < 0 || sp.PidsLimit < 0 || sp.NofileLimit < 0 || sp.FsizeMB < 0 {
+		return errors.New("sandbox limits must not be negative")
+	}
+	return nil
+}
+
+// tmpfs returns the tmpfs mounts the profile asks for, keyed by target.
+func (sp SecurityProfile) tmpfs() map[string]string {
+	mounts := make(map[string]string)
+	if sp.WorkDirSizeMB > 0 {
+		mounts["/app"] = fmt.Sprintf("rw,exec,nosuid,nodev,size=%dm,mode=1777", sp.WorkDirSizeMB)
+	}
+	if sp.TmpSizeMB > 0 {
+		mounts["/tmp"] = fmt.Sprintf("rw,exec,nosuid,nodev,size=%dm,mode=1777", sp.TmpSizeMB)
+	}
+	return mounts
+}
+
+type ulimit struct {
+	Name string `json:"Name"`
+	Soft int64  `json:"Soft"`
+	Hard int64  `json:"Hard"`
+}
+
+func (sp SecurityProfile) ulimits() []ulimit {
+	var limits []ulimit
+	if sp.NofileLimit > 0 {
+		limits = append(limits, ulimit{Name: "nofile", Soft: int64(sp.NofileLimit), Hard: int64(sp.NofileLimit)})
+	}
+	if sp.FsizeMB > 0 {
+		bytes := int64(sp.FsizeMB) * 1024 * 1024
+		limits = append(limits, ulimit{Name: "fsize", Soft: bytes, Hard: bytes})
+	}
+	return limits
+}
+
+// dockerArgs translates the profile into "docker run" flags.
+func (sp SecurityProfile) dockerArgs() []string {
+	var args []string
+
+	if sp.ReadOnlyRootfs {
+		args = append(args, "--read-only")
+	}
+	tmpfs := sp.tmpfs()
+	for _, target := range slices.Sorted(maps.Keys(tmpfs)) {
+		args = append(args, "--tmpfs", target+":"+tmpfs[target])
+	}
+	if sp.DropAllCaps {
+		args = append(args, "--cap-drop", "ALL")
+	}
+	if sp.NoNewPrivileges {
+		args = append(args, "--security-opt", "no-new-privileges")
+	}
+	if sp.User != "" {
+		args = append(args, "--user", sp.User)
+	}
+	if sp.PidsLimit > 0 {
+		args = append(args, "--pids-limit", fmt.Sprint(sp.PidsLimit))
+	}
+	for _, l := range sp.ulimits() {
+		args = append(args, "--ulimit", fmt.Sprintf("%s=%d:%d", l.Name, l.Soft, l.Hard))
+	}
+	if sp.SeccompProfile != "" {
+		args = append(args, "--security-opt", "seccomp="+sp.SeccompProfile)
+	}
+	if sp.Runtime != "" {
+		args = append(args, "--runtime", sp.Runtime)
+	}
+	return args
+}
@@ -0,0 +1,129 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// newTestDockerRunner returns a DockerRunner for python with the given
+// profile, skipping the test when there is no Docker daemon or the image
+// cannot be pulled. The image is pinned by the digest Docker pulled, so the
+// tests do not depend on the pins of the shipped languages file.
+func newTestDockerRunner(t *testing.T, security SecurityProfile) *DockerRunner {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("skipping sandbox test in short mode")
+	}
+	if err := exec.Command("docker", "info").Run(); err != nil {
+		t.Skip("skipping sandbox test: no Docker daemon available")
+	}
+
+	const tag = "python:3.13-alpine"
+	if out, err := exec.Command("docker", "pull", "--quiet", tag).CombinedOutput(); err != nil {
+		t.Skipf("skipping sandbox test: cannot pull %s: %s", tag, bytes.TrimSpace(out))
+	}
+	out, err := exec.Command("docker", "image", "inspect", "--format", "{{index .RepoDigests 0}}", tag).Output()
+	if err != nil {
+		t.Fatalf("inspecting %s: %v", tag, err)
+	}
+
+	python := Language{
+		ID:         "python",
+		Name:       "Python",
+		Extension:  ".py",
+		Image:      strings.TrimSpace(string(out)),
+		Version:    "3.13",
+		FileName:   "main.py",
+		RunCommand: []string{"python", "{file}"},
+	}
+	if err := python.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	dr, err := NewDockerRunner(NewRegistry(python), DockerOptions{Security: security})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(dr.Close)
+	return dr
+}
+
+func TestSandboxStopsForkBomb(t *testing.T) {
+	security := DefaultSecurityProfile()
+	security.PidsLimit = 32
+	dr := newTestDockerRunner(t, security)
+
+	// Children sleep instead of exiting, so every fork holds on to a pid
+	// until the limit refuses the next one.
+	code := `
+import os, sys, time
+n = 0
+try:
+    while True:
+        if os.fork() == 0:
+            time.sleep(60)
+            os._exit(0)
+        n += 1
+except OSError:
+    print("forks", n)
+    sys.exit(3)
+`
+	res, err := dr.Run(context.Background(), ExecuteRequest{Language: "python", Code: code})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Status != StatusRuntimeError || res.ExitCode != 3 {
+		t.Fatalf("got status %q, exit code %d, want %q, 3; output %q, error %q", res.Status, res.ExitCode, StatusRuntimeError, res.Output, res.Error)
+	}
+	if !strings.HasPrefix(res.Output, "forks ") {
+		t.Errorf("got output %q, want the number of forks", res.Output)
+	}
+}
+
+func TestSandboxStopsDiskFill(t *testing.T) {
+	security := DefaultSecurityProfile()
+	security.WorkDirSizeMB = 8
+	security.TmpSizeMB = 8
+	dr := newTestDockerRunner(t, security)
+
+	// Files of 1MB each stay below the fsize ulimit, so only the size of
+	// the /tmp tmpfs can stop the writes.
+	code := `
+import errno, sys
+written = 0
+try:
+    while True:
+        with open("/tmp/fill%d" % written, "wb") as f:
+            f.write(b"x" * (1 << 20))
+        written += 1
+except OSError as e:
+    print(errno.errorcode[e.errno], written)
+    sys.exit(3)
+`
+	res, err := dr.Run(context.Background(), ExecuteRequest{Language: "python", Code: code})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Status != StatusRuntimeError || res.ExitCode != 3 {
+		t.Fatalf("got status %q, exit code %d, want %q, 3; output %q, error %q", res.Status, res.ExitCode, StatusRuntimeError, res.Output, res.Error)
+	}
+	var name string
+	var written int
+	_, err = fmt.Sscan(res.Output, &name, &written)
+	if err != nil {
+		t.Fatalf("unexpected output %q: %v", res.Output, err)
+	}
+	if name != "ENOSPC" {
+		t.Errorf("got %s, want ENOSPC", name)
+	}
+	if written > security.TmpSizeMB {
+		t.Errorf("wrote %dMB to a %dMB /tmp", written, security.TmpSizeMB)
+	}
+}
@@ -0,0 +1,238 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+var ErrInteractiveUnsupported = errors.New("interactive sessions need the cli docker backend")
+
+var (
+	errSessionIdle       = errors.New("session idle")
+	errSessionTerminated = errors.New("session terminated")
+)
+
+// SessionLimits bounds an interactive session. Total replaces the language's
+// run timeout, Idle ends a session that saw neither input nor output for
+// that long.
+type SessionLimits struct {
+	Idle  time.Duration
+	Total time.Duration
+}
+
+var DefaultSessionLimits = SessionLimits{Idle: 2 * time.Minute, Total: 15 * time.Minute}
+
+// TerminalSize is the size of a session's terminal in characters.
+type TerminalSize struct {
+	Rows uint16 `json:"rows"`
+	Cols uint16 `json:"cols"`
+}
+
+// InteractiveRunner starts programs attached to a pseudo-terminal, for
+// programs that prompt for input. Stdin and Timeout of the request are not
+// used.
+type InteractiveRunner interface {
+	Start(ctx context.Context, req ExecuteRequest, limits SessionLimits, size TerminalSize) (*Session, error)
+}
+
+// Session is a running interactive program. Reading returns the terminal's
+// output and io.EOF once the program has exited, writing types into the
+// terminal. Wait returns the result after that, with the whole transcript
+// (up to the stdout limit, and cleaned up like any output) as its Output.
+type Session struct {
+	pty        *os.File
+	cancel     context.CancelCauseFunc
+	lastActive atomic.Int64
+
+	mu         sync.Mutex
+	transcript limitedBuffer
+	output     OutputOptions
+
+	done   chan struct{}
+	result *ExecuteResult
+	err    error
+}
+
+func newSession(master *os.File, cancel context.CancelCauseFunc, output OutputOptions) *Session {
+	s := &Session{
+		pty:        master,
+		cancel:     cancel,
+		transcript: limitedBuffer{max: output.StdoutBytes},
+		output:     output,
+		done:       make(chan struct{}),
+	}
+	s.touch()
+	return s
+}
+
+// finishedSession is a session whose program never ran, because the
+// install or compilation failed.
+func finishedSession(result *ExecuteResult) *Session {
+	s := newSession(nil, func(error) {}, OutputOptions{})
+	s.result = result
+	close(s.done)
+	return s
+}
+
+func (s *Session) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+func (s *Session) Read(p []byte) (int, error) {
+	if s.pty == nil {
+		return 0, io.EOF
+	}
+	n, err := s.pty.Read(p)
+	if n > 0 {
+		s.touch()
+		s.mu.Lock()
+		s.transcript.Write(p[:n])
+		s.mu.Unlock()
+	}
+	// Linux reports EIO on the master once the program's side closed.
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (s *Session) Write(p []byte) (int, error) {
+	if s.pty == nil {
+		return 0, errors.New("session has ended")
+	}
+	s.touch()
+	return s.pty.Write(p)
+}
+
+func (s *Session) Resize(size TerminalSize) error {
+	if s.pty == nil {
+		return nil
+	}
+	return pty.Setsize(s.pty, &pty.Winsize{Rows: size.Rows, Cols: size.Cols})
+}
+
+// Terminate kills the program. Wait then reports StatusTerminated.
+func (s *Session) Terminate() {
+	s.cancel(errSessionTerminated)
+}
+
+// Done is closed when the program has exited and its container is gone.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Session) Wait() (*ExecuteResult, error) {
+	<-s.done
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	result := *s.result
+	s.mu.Lock()
+	result.Output = s.output.sanitize(s.transcript.String())
+	result.Truncated = result.Truncated || s.transcript.truncated
+	s.mu.Unlock()
+	return &result, nil
+}
+
+// Close terminates the program if it still runs and releases the terminal.
+// Output not read by then is lost.
+func (s *Session) Close() error {
+	s.Terminate()
+	if s.pty == nil {
+		return nil
+	}
+	return s.pty.Close()
+}
+
+// watchIdle ends the session once it has been idle for longer than idle.
+func (s *Session) watchIdle(idle time.Duration) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, s.lastActive.Load())) > idle {
+				s.cancel(errSessionIdle)
+				return
+			}
+		}
+	}
+}
+
+// Start installs and compiles req like Run, then starts its run phase on a
+// pseudo-terminal and returns straight away. The session outlives ctx only
+// for as long as it takes to clean up after ctx ends.
+func (s *sandbox) Start(ctx context.Context, req ExecuteRequest, limits SessionLimits, size TerminalSize) (*Session, error) {
+	if _, ok := s.engine.(*cliEngine); !ok {
+		return nil, ErrInteractiveUnsupported
+	}
+
+	tmpDir, err := os.MkdirTemp("", "runner-*")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create temp dir: %w", err)
+	}
+
+	ws, result, err := s.prepare(ctx, tmpDir, req)
+	if err != nil || result != nil {
+		os.RemoveAll(tmpDir)
+		if err != nil {
+			return nil, err
+		}
+		return finishedSession(result), nil
+	}
+
+	master, tty, err := pty.Open()
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, fmt.Errorf("failed to open a pseudo-terminal: %w", err)
+	}
+	if size.Rows > 0 && size.Cols > 0 {
+		pty.Setsize(master, &pty.Winsize{Rows: size.Rows, Cols: size.Cols})
+	}
+
+	sessionCtx, cancel := context.WithCancelCause(ctx)
+	sess := newSession(master, cancel, s.output)
+
+	p := ws.runPhase(req)
+	p.stdin = nil
+	p.limits.Timeout = Duration(limits.Total)
+	p.tty = tty
+
+	go sess.watchIdle(limits.Idle)
+	go func() {
+		defer os.RemoveAll(tmpDir)
+		defer cancel(nil)
+
+		result, err := s.runPhase(sessionCtx, tmpDir, p)
+		// With its last other end gone, reads of the master end in EOF.
+		tty.Close()
+
+		switch cause := context.Cause(sessionCtx); {
+		case err != nil && errors.Is(cause, errSessionIdle):
+			result = &ExecuteResult{Status: StatusTimeout, ExitCode: -1, Error: "Session was idle for too long"}
+			err = nil
+		case err != nil && errors.Is(cause, errSessionTerminated):
+			result = &ExecuteResult{Status: StatusTerminated, ExitCode: -1, Error: "Session was terminated"}
+			err = nil
+		}
+		if result != nil {
+			result.Compile = ws.compile
+			result.Install = ws.install
+		}
+		sess.result, sess.err = result, err
+		close(sess.done)
+	}()
+	return sess, nil
+}
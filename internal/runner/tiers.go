@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TierLimits caps the run limits users of each tier may ask for, on top of
+// the maximum of every language. Zero fields leave that limit to the
+// language alone, and so do tiers that are not listed.
+type TierLimits map[string]Limits
+
+func (t TierLimits) Get(tier string) Limits {
+	return t[tier]
+}
+
+// ParseTierLimits reads tier limits in the form
+// "tier:timeout:memoryMB:cpus,...", such as "free:5s:128:0.5,pro:30s:512:1".
+// Any of the limits may be left empty.
+func ParseTierLimits(s string) (TierLimits, error) {
+	tiers := make(TierLimits)
+	if strings.TrimSpace(s) == "" {
+		return tiers, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		fields := strings.Split(strings.TrimSpace(entry), ":")
+		if len(fields) != 4 || fields[0] == "" {
+			return nil, fmt.Errorf("invalid tier limits %q: must be tier:timeout:memoryMB:cpus", entry)
+		}
+
+		var limits Limits
+		if fields[1] != "" {
+			d, err := time.ParseDuration(fields[1])
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid tier limits %q: timeout must be a positive duration", entry)
+			}
+			limits.Timeout = Duration(d)
+		}
+		if fields[2] != "" {
+			mb, err := strconv.Atoi(fields[2])
+			if err != nil || mb < MinMemoryMB {
+				return nil, fmt.Errorf("invalid tier limits %q: memory must be at least %dMB", entry, MinMemoryMB)
+			}
+			limits.MemoryMB = mb
+		}
+		if fields[3] != "" {
+			cpus, err := strconv.ParseFloat(fields[3], 64)
+			if err != nil || cpus < MinCPUs {
+				return nil, fmt.Errorf("invalid tier limits %q: cpus must be at least %g", entry, MinCPUs)
+			}
+			limits.CPUs = cpus
+		}
+		tiers[fields[0]] = limits
+	}
+	return tiers, nil
+}
@@ -0,0 +1,236 @@
+package runner
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// warmMaxAge bounds how long a warm container may wait for a run. Reap adds
+// it to the grace period of warm containers, so those of a live process
+// sharing the Docker host are never mistaken for orphans.
+const warmMaxAge = 10 * time.Minute
+
+// warmKey identifies containers that are interchangeable for a run phase.
+// The timeout is enforced from outside, so it is not part of the key.
+type warmKey struct {
+	image    string
+	memoryMB int
+	cpus     float64
+}
+
+// configuredKeys returns the keys the pool keeps containers for: every
+// version of every language, with the language's default run limits. Runs
+// that ask for other limits always start a fresh container, so requests
+// cannot make the pool warm up containers of their choosing.
+func configuredKeys(languages *Registry) map[warmKey]bool {
+	keys := make(map[warmKey]bool)
+	for _, lang := range languages.All() {
+		for _, v := range lang.Versions {
+			keys[warmKey{image: v.Image, memoryMB: lang.RunLimits.MemoryMB, cpus: lang.RunLimits.CPUs}] = true
+		}
+	}
+	return keys
+}
+
+type warmContainer struct {
+	name    string
+	created time.Time
+}
+
+// warmPool keeps up to size paused containers ready for the run phase of
+// every configured language and version. Each container is used for a single run and a
+// replacement is started in the background straight away.
+type warmPool struct {
+	engine    *cliEngine
+	languages *Registry
+	security  SecurityProfile
+	size      int
+
+	mu sync.Mutex
+	// keys are the configuredKeys of the languages, as of the last
+	// refresh.
+	keys    map[warmKey]bool
+	idle    map[warmKey][]warmContainer
+	filling map[warmKey]int
+	closed  bool
+
+	hits    int64
+	misses  int64
+	created int64
+	failed  int64
+
+	stop chan struct{}
+}
+
+type WarmPoolStats struct {
+	Size    int   `json:"size"`
+	Idle    int   `json:"idle"`
+	Filling int   `json:"filling"`
+	Hits    int64 `json:"hits_total"`
+	Misses  int64 `json:"misses_total"`
+	Created int64 `json:"created_total"`
+	Failed  int64 `json:"failed_total"`
+}
+
+func newWarmPool(engine *cliEngine, languages *Registry, security SecurityProfile, size int) *warmPool {
+	p := &warmPool{
+		engine:    engine,
+		languages: languages,
+		security:  security,
+		size:      size,
+		keys:      configuredKeys(languages),
+		idle:      make(map[warmKey][]warmContainer),
+		filling:   make(map[warmKey]int),
+		stop:      make(chan struct{}),
+	}
+	go p.maintain()
+	return p
+}
+
+// get hands out a warm container for key, if one is ready, and starts a
+// replacement either way. Keys that are not configured are never warm.
+func (p *warmPool) get(key warmKey) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.keys[key] {
+		return "", false
+	}
+	defer p.fill(key)
+
+	idle := p.idle[key]
+	for len(idle) > 0 {
+		c := idle[len(idle)-1]
+		idle = idle[:len(idle)-1]
+		if time.Since(c.created) < warmMaxAge {
+			p.idle[key] = idle
+			p.hits++
+			return c.name, true
+		}
+		go p.engine.remove(c.name)
+	}
+	p.idle[key] = idle
+	p.misses++
+	return "", false
+}
+
+// fill starts creating containers until key has size of them, counting the
+// ones already on their way. It must be called with p.mu held.
+func (p *warmPool) fill(key warmKey) {
+	if p.closed {
+		return
+	}
+	for n := len(p.idle[key]) + p.filling[key]; n < p.size; n++ {
+		p.filling[key]++
+		go p.create(key)
+	}
+}
+
+func (p *warmPool) create(key warmKey) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	created := time.Now()
+	name, err := containerName()
+	if err == nil {
+		err = p.engine.createWarm(ctx, containerSpec{
+			name:     name,
+			image:    key.image,
+			limits:   Limits{MemoryMB: key.memoryMB, CPUs: key.cpus},
+			security: p.security,
+			warm:     true,
+		})
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.filling[key]--
+	if err != nil {
+		p.failed++
+		return
+	}
+	p.created++
+	if p.closed {
+		go p.engine.remove(name)
+		return
+	}
+	p.idle[key] = append(p.idle[key], warmContainer{name: name, created: created})
+}
+
+func (p *warmPool) maintain() {
+	ticker := time.NewTicker(warmMaxAge / 6)
+	defer ticker.Stop()
+
+	for {
+		p.refresh()
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh replaces containers that are halfway to expiring, drops those of
+// languages and versions that are no longer configured, and tops up every
+// current one.
+func (p *warmPool) refresh() {
+	current := configuredKeys(p.languages)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keys = current
+
+	for key, idle := range p.idle {
+		var kept []warmContainer
+		for _, c := range idle {
+			if current[key] && time.Since(c.created) < warmMaxAge/2 {
+				kept = append(kept, c)
+			} else {
+				go p.engine.remove(c.name)
+			}
+		}
+		p.idle[key] = kept
+	}
+	for key := range current {
+		p.fill(key)
+	}
+}
+
+func (p *warmPool) close() {
+	p.mu.Lock()
+	p.closed = true
+	idle := p.idle
+	p.idle = make(map[warmKey][]warmContainer)
+	p.mu.Unlock()
+
+	close(p.stop)
+	for _, containers := range idle {
+		for _, c := range containers {
+			p.engine.remove(c.name)
+		}
+	}
+}
+
+func (p *warmPool) stats() WarmPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := WarmPoolStats{
+		Size:    p.size,
+		Hits:    p.hits,
+		Misses:  p.misses,
+		Created: p.created,
+		Failed:  p.failed,
+	}
+	for _, idle := range p.idle {
+		stats.Idle += len(idle)
+	}
+	for _, n := range p.filling {
+		stats.Filling += n
+	}
+	return stats
+}
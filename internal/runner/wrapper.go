@@ -0,0 +1,97 @@
+package runner
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// wrapperScript runs inside every container in front of the real command.
+// It copies the sources from the /src mount into the /app work dir, runs the
+// command and, for phases whose output is needed later (compilation), copies
+// /app back to /src. Finally it records the container's cgroup v2 counters
+// in /runner/stats. On hosts without cgroup v2 those values are simply
+// missing and the result reports zeros.
+const wrapperScript = `mode=$1
+shift
+if ! cp -R /src/. /app/; then
+	echo "setup_failed 1" > /runner/stats
+	exit 1
+fi
+cd /app
+"$@"
+status=$?
+setup_failed=0
+if [ "$mode" = save ]; then
+	{ cp -R /app/. /src/ && chmod -R a+rwX /src; } || setup_failed=1
+fi
+cg=/sys/fs/cgroup
+{
+	echo "setup_failed $setup_failed"
+	echo "peak_memory $(cat $cg/memory.peak 2>/dev/null)"
+	grep -s '^usage_usec ' $cg/cpu.stat
+	grep -s '^oom_kill ' $cg/memory.events
+} > /runner/stats
+exit $status`
+
+const (
+	srcMountPath    = "/src"
+	statsMountPath  = "/runner/stats"
+	reportMountPath = "/runner/report"
+	replMountPath   = "/runner/repl"
+
+	// maxReportBytes caps how much of a test report is read back. The
+	// sandbox's fsize limit bounds what is written in the first place.
+	maxReportBytes = 1024 * 1024
+)
+
+type containerStats struct {
+	setupFailed bool
+	peakMemory  int64
+	cpuUsec     int64
+	oomKills    int64
+}
+
+// wrap prefixes command with wrapperScript. When save is set the work dir is
+// copied back to /src after the command exits.
+func wrap(command []string, save bool) []string {
+	mode := "discard"
+	if save {
+		mode = "save"
+	}
+	return append([]string{"sh", "-c", wrapperScript, "sh", mode}, command...)
+}
+
+func readStats(path string) containerStats {
+	var stats containerStats
+
+	f, err := os.Open(path)
+	if err != nil {
+		return stats
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "setup_failed":
+			stats.setupFailed = n != 0
+		case "peak_memory":
+			stats.peakMemory = n
+		case "usage_usec":
+			stats.cpuUsec = n
+		case "oom_kill":
+			stats.oomKills = n
+		}
+	}
+	return stats
+}
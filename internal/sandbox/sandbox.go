@@ -0,0 +1,167 @@
+// Package sandbox sets up the runner that executes code from the
+// command-line flags shared by the API server and the standalone worker.
+package sandbox
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/VJ-2303/code-runner/internal/runner"
+)
+
+type Config struct {
+	Backend string
+	Docker  struct {
+		Engine   string
+		Backend  string
+		Host     string
+		WarmPool int
+	}
+	Deps struct {
+		CacheDir        string
+		Network         string
+		Mirror          string
+		MaxCacheMB      int
+		InstallsPerHour int
+	}
+	Output struct {
+		StdoutKB int
+		StderrKB int
+		Control  string
+	}
+	Namespace struct {
+		Bwrap      string
+		RootfsDir  string
+		CgroupRoot string
+	}
+	Security      runner.SecurityProfile
+	LanguagesFile string
+	// Interactive is set by processes that host terminals and REPL
+	// sessions, so that the docker api backend, which cannot, is refused
+	// at startup.
+	Interactive bool
+}
+
+// RegisterFlags defines the sandbox flags on the default flag set.
+func (c *Config) RegisterFlags() {
+	flag.StringVar(&c.Backend, "runner-backend", "docker", "Sandbox used to execute code (docker|namespace)")
+
+	flag.StringVar(&c.Docker.Engine, "container-engine", runner.EngineDocker, "Container engine used by the docker backend (docker|podman)")
+	flag.StringVar(&c.Docker.Backend, "docker-backend", runner.BackendCLI, "How to talk to Docker (cli|api, the API server needs cli for terminals and REPL sessions)")
+	flag.StringVar(&c.Docker.Host, "docker-host", runner.DefaultDockerHost, "Docker Engine API socket, used by the api backend")
+	flag.IntVar(&c.Docker.WarmPool, "docker-warm-pool", 0, "Paused containers kept ready per language (0 disables the pool, needs the cli backend)")
+
+	flag.StringVar(&c.Deps.CacheDir, "deps-cache-dir", "", "Directory caching installed dependency manifests (empty disables dependency installs)")
+	flag.StringVar(&c.Deps.Network, "deps-network", "code-runner-deps", "Docker network of dependency installs, which should only reach the package mirror")
+	flag.StringVar(&c.Deps.Mirror, "deps-mirror", "", "Base URL of the package mirror used by dependency installs")
+	flag.IntVar(&c.Deps.MaxCacheMB, "deps-cache-mb", 4096, "Size of the dependency cache in MB, beyond which the least recently used manifests are removed (0 disables the limit)")
+	flag.IntVar(&c.Deps.InstallsPerHour, "deps-installs-per-hour", 20, "Manifests that are not cached yet each user may install per hour (0 disables the limit)")
+
+	flag.IntVar(&c.Output.StdoutKB, "output-stdout-kb", runner.DefaultOutputOptions.StdoutBytes/1024, "Stdout kept per phase, in KB; programs writing more are killed")
+	flag.IntVar(&c.Output.StderrKB, "output-stderr-kb", runner.DefaultOutputOptions.StderrBytes/1024, "Stderr kept per phase, in KB; programs writing more are killed")
+	flag.StringVar(&c.Output.Control, "output-control", runner.DefaultOutputOptions.Control, "What to do with control characters in output (strip|escape)")
+
+	flag.StringVar(&c.Namespace.Bwrap, "namespace-bwrap", "bwrap", "bubblewrap binary, used by the namespace backend")
+	flag.StringVar(&c.Namespace.RootfsDir, "namespace-rootfs-dir", "", "Directory of extracted language images, used by the namespace backend")
+	flag.StringVar(&c.Namespace.CgroupRoot, "namespace-cgroup", "", "Delegated cgroup v2 directory, used by the namespace backend")
+
+	c.Security = runner.DefaultSecurityProfile()
+	flag.BoolVar(&c.Security.ReadOnlyRootfs, "sandbox-read-only", c.Security.ReadOnlyRootfs, "Mount the sandbox root filesystem read-only")
+	flag.IntVar(&c.Security.WorkDirSizeMB, "sandbox-workdir-mb", c.Security.WorkDirSizeMB, "Size of the sandbox /app tmpfs in MB (0 disables the tmpfs)")
+	flag.IntVar(&c.Security.TmpSizeMB, "sandbox-tmp-mb", c.Security.TmpSizeMB, "Size of the sandbox /tmp tmpfs in MB (0 disables the tmpfs)")
+	flag.BoolVar(&c.Security.DropAllCaps, "sandbox-cap-drop-all", c.Security.DropAllCaps, "Drop all Linux capabilities in the sandbox")
+	flag.BoolVar(&c.Security.NoNewPrivileges, "sandbox-no-new-privileges", c.Security.NoNewPrivileges, "Prevent privilege escalation in the sandbox")
+	flag.StringVar(&c.Security.User, "sandbox-user", c.Security.User, "UID:GID the sandbox runs as (empty keeps the image default)")
+	flag.IntVar(&c.Security.PidsLimit, "sandbox-pids-limit", c.Security.PidsLimit, "Maximum number of processes and threads in the sandbox")
+	flag.IntVar(&c.Security.NofileLimit, "sandbox-nofile", c.Security.NofileLimit, "Maximum number of open files in the sandbox")
+	flag.IntVar(&c.Security.FsizeMB, "sandbox-fsize-mb", c.Security.FsizeMB, "Maximum size of a single file written in the sandbox in MB")
+	flag.StringVar(&c.Security.SeccompProfile, "sandbox-seccomp", "", "Path to a seccomp profile for the sandbox (empty uses Docker's default)")
+	flag.StringVar(&c.Security.Runtime, "sandbox-runtime", "", "OCI runtime for the sandbox, for example runsc")
+
+	flag.StringVar(&c.LanguagesFile, "languages-file", "languages.json", "Path to the JSON file with the language definitions, every image pinned by digest (reloaded on SIGHUP)")
+}
+
+// Runner is a runner.Runner that hosts REPL sessions and can clean up after
+// a previous crash and before shutting down.
+type Runner interface {
+	runner.Runner
+	runner.REPLRunner
+	Reap(ctx context.Context, olderThan time.Duration) (int, error)
+	Close()
+}
+
+func (c Config) Open(languages *runner.Registry) (Runner, error) {
+	switch c.Backend {
+	case "docker":
+		return runner.NewDockerRunner(languages, runner.DockerOptions{
+			Engine:      c.Docker.Engine,
+			Backend:     c.Docker.Backend,
+			Host:        c.Docker.Host,
+			Security:    c.Security,
+			WarmPool:    c.Docker.WarmPool,
+			Interactive: c.Interactive,
+			Deps: runner.DepsOptions{
+				CacheDir:        c.Deps.CacheDir,
+				Network:         c.Deps.Network,
+				Mirror:          c.Deps.Mirror,
+				MaxCacheMB:      c.Deps.MaxCacheMB,
+				InstallsPerHour: c.Deps.InstallsPerHour,
+			},
+			Output: c.outputOptions(),
+		})
+	case "namespace":
+		return runner.NewNamespaceRunner(languages, runner.NamespaceOptions{
+			Bwrap:      c.Namespace.Bwrap,
+			RootfsDir:  c.Namespace.RootfsDir,
+			CgroupRoot: c.Namespace.CgroupRoot,
+			Security:   c.Security,
+			Output:     c.outputOptions(),
+		})
+	}
+	return nil, fmt.Errorf("unknown runner backend %q", c.Backend)
+}
+
+func (c Config) outputOptions() runner.OutputOptions {
+	return runner.OutputOptions{
+		StdoutBytes: c.Output.StdoutKB * 1024,
+		StderrBytes: c.Output.StderrKB * 1024,
+		Control:     c.Output.Control,
+	}
+}
+
+func (c Config) OpenLanguages() (*runner.Registry, error) {
+	return runner.LoadRegistry(c.LanguagesFile)
+}
+
+// ReloadLanguages re-reads the languages file into languages. Runs that
+// already looked up their language keep using the old definition, and a
+// broken file leaves the current languages in place.
+func (c Config) ReloadLanguages(logger *slog.Logger, languages *runner.Registry) {
+	err := languages.Reload(c.LanguagesFile)
+	if err != nil {
+		logger.Error("reloading languages failed", "file", c.LanguagesFile, "error", err)
+		return
+	}
+	logger.Info("languages reloaded", "file", c.LanguagesFile, "languages", languages.IDs())
+}
+
+// Reap removes sandbox containers orphaned by a previous crash.
+// Anything younger than five minutes could still belong to another process
+// on the same host, which is longer than any run or dependency install is
+// allowed to take.
+func Reap(logger *slog.Logger, sandbox Runner) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	n, err := sandbox.Reap(ctx, 5*time.Minute)
+	if err != nil {
+		logger.Error("reaping orphaned containers failed", "error", err)
+		return
+	}
+	if n > 0 {
+		logger.Info("reaped orphaned containers", "count", n)
+	}
+}
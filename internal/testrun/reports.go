@@ -0,0 +1,251 @@
+package testrun
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxMessageBytes caps each failure message, the full output is in the run.
+const maxMessageBytes = 4096
+
+func message(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > maxMessageBytes {
+		s = strings.ToValidUTF8(s[:maxMessageBytes], "") + "..."
+	}
+	return s
+}
+
+// parseJUnit reads the testcase elements of a JUnit XML report, however
+// deeply their suites are nested.
+func parseJUnit(report string) ([]Test, error) {
+	type outcome struct {
+		Message string `xml:"message,attr"`
+		Text    string `xml:",chardata"`
+	}
+	type testcase struct {
+		Name      string   `xml:"name,attr"`
+		Classname string   `xml:"classname,attr"`
+		Time      string   `xml:"time,attr"`
+		Failure   *outcome `xml:"failure"`
+		Error     *outcome `xml:"error"`
+		Skipped   *outcome `xml:"skipped"`
+	}
+
+	var tests []Test
+	dec := xml.NewDecoder(strings.NewReader(report))
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return tests, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "testcase" {
+			continue
+		}
+
+		var tc testcase
+		err = dec.DecodeElement(&tc, &start)
+		if err != nil {
+			return nil, err
+		}
+
+		test := Test{Name: tc.Name, Status: TestPassed}
+		if tc.Classname != "" {
+			test.Name = tc.Classname + "." + tc.Name
+		}
+		if seconds, err := strconv.ParseFloat(tc.Time, 64); err == nil {
+			test.DurationMS = seconds * 1000
+		}
+		for _, o := range []*outcome{tc.Failure, tc.Error} {
+			if o != nil && test.Status == TestPassed {
+				test.Status = TestFailed
+				test.Message = message(o.Message + "\n" + o.Text)
+			}
+		}
+		if tc.Skipped != nil && test.Status == TestPassed {
+			test.Status = TestSkipped
+			test.Message = message(tc.Skipped.Message)
+		}
+		tests = append(tests, test)
+	}
+}
+
+// parseTAP reads TAP 13/14 test points with their YAML diagnostics, as
+// written by node --test. Subtests come before the point of their parent,
+// which only counts as a test itself when it has none; its name prefixes
+// theirs instead.
+func parseTAP(report string) []Test {
+	type point struct {
+		Test
+		indent int
+	}
+
+	var points []point
+	var diag *point
+	// A multi-line error message continues on the lines indented deeper
+	// than its key.
+	var inError bool
+	var errIndent int
+
+	scanner := bufio.NewScanner(strings.NewReader(report))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimLeft(line, " ")
+		indent := len(line) - len(trimmed)
+
+		ok := strings.HasPrefix(trimmed, "ok ")
+		if !ok && !strings.HasPrefix(trimmed, "not ok ") {
+			if diag != nil && indent > diag.indent {
+				if inError && indent > errIndent {
+					diag.Message = message(diag.Message + "\n" + trimmed)
+					continue
+				}
+				inError = false
+				key, value, _ := strings.Cut(trimmed, ":")
+				value = strings.TrimSpace(value)
+				switch key {
+				case "duration_ms":
+					diag.DurationMS, _ = strconv.ParseFloat(value, 64)
+				case "error":
+					if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+						inError, errIndent = true, indent
+					} else {
+						diag.Message = message(strings.Trim(value, `'"`))
+					}
+				}
+			}
+			continue
+		}
+
+		// "ok 1 - name # SKIP reason"
+		_, rest, _ := strings.Cut(trimmed, "ok ")
+		rest = strings.TrimLeft(rest, "0123456789")
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), "- ")
+		name, directive, _ := strings.Cut(rest, " # ")
+
+		p := point{Test: Test{Name: strings.TrimSpace(name), Status: TestPassed}, indent: indent}
+		if !ok {
+			p.Status = TestFailed
+		}
+		directive = strings.ToUpper(directive)
+		if strings.HasPrefix(directive, "SKIP") || strings.HasPrefix(directive, "TODO") {
+			p.Status = TestSkipped
+		}
+
+		parent := false
+		for i := len(points) - 1; i >= 0 && points[i].indent > indent; i-- {
+			points[i].Name = p.Name + " > " + points[i].Name
+			points[i].indent = indent
+			parent = true
+		}
+		if parent {
+			diag = nil
+			continue
+		}
+		points = append(points, p)
+		diag = &points[len(points)-1]
+		inError = false
+	}
+
+	tests := make([]Test, len(points))
+	for i, p := range points {
+		tests[i] = p.Test
+	}
+	return tests
+}
+
+// parseGoJSON reads the events of "go test -json". The output of a test is
+// its failure message when it fails.
+func parseGoJSON(report string) []Test {
+	type event struct {
+		Action  string
+		Test    string
+		Elapsed float64
+		Output  string
+	}
+
+	var tests []Test
+	output := make(map[string]*strings.Builder)
+
+	for _, line := range strings.Split(report, "\n") {
+		var e event
+		if json.Unmarshal([]byte(line), &e) != nil || e.Test == "" {
+			continue
+		}
+		switch e.Action {
+		case "output":
+			b, ok := output[e.Test]
+			if !ok {
+				b = &strings.Builder{}
+				output[e.Test] = b
+			}
+			if b.Len() < maxMessageBytes {
+				b.WriteString(e.Output)
+			}
+		case "pass", "fail", "skip":
+			test := Test{Name: e.Test, DurationMS: e.Elapsed * 1000}
+			switch e.Action {
+			case "pass":
+				test.Status = TestPassed
+			case "fail":
+				test.Status = TestFailed
+				if b, ok := output[e.Test]; ok {
+					test.Message = message(b.String())
+				}
+			case "skip":
+				test.Status = TestSkipped
+			}
+			tests = append(tests, test)
+		}
+	}
+	return tests
+}
+
+// parseRSpec reads the examples of rspec's JSON formatter.
+func parseRSpec(report string) ([]Test, error) {
+	var doc struct {
+		Examples []struct {
+			FullDescription string  `json:"full_description"`
+			Status          string  `json:"status"`
+			RunTime         float64 `json:"run_time"`
+			PendingMessage  string  `json:"pending_message"`
+			Exception       *struct {
+				Class   string `json:"class"`
+				Message string `json:"message"`
+			} `json:"exception"`
+		} `json:"examples"`
+	}
+	err := json.Unmarshal([]byte(report), &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	tests := make([]Test, 0, len(doc.Examples))
+	for _, ex := range doc.Examples {
+		test := Test{Name: ex.FullDescription, DurationMS: ex.RunTime * 1000}
+		switch ex.Status {
+		case "passed":
+			test.Status = TestPassed
+		case "pending":
+			test.Status = TestSkipped
+			test.Message = message(ex.PendingMessage)
+		default:
+			test.Status = TestFailed
+			if ex.Exception != nil {
+				test.Message = message(ex.Exception.Class + ": " + ex.Exception.Message)
+			}
+		}
+		tests = append(tests, test)
+	}
+	return tests, nil
+}
@@ -0,0 +1,336 @@
+package testrun
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/VJ-2303/code-runner/internal/runner"
+)
+
+// reportCap is how much of a report the runner reads back, see
+// maxReportBytes in package runner.
+const reportCap = 1024 * 1024
+
+// The reports in testdata were written by pytest --junitxml, node --test
+// --test-reporter=tap, go test -json and rspec --format json.
+func readReport(t *testing.T, name string) string {
+	t.Helper()
+
+	b, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestParse(t *testing.T) {
+	pytest := readReport(t, "pytest.xml")
+	tap := readReport(t, "node.tap")
+	goJSON := readReport(t, "go.json")
+	rspec := readReport(t, "rspec.json")
+
+	tests := []struct {
+		name    string
+		format  string
+		report  string
+		want    []Test
+		wantErr bool
+	}{
+		{
+			name:   "pytest",
+			format: runner.ReportJUnit,
+			report: pytest,
+			want: []Test{
+				{Name: "test_main.test_add", Status: TestPassed, DurationMS: 1},
+				{Name: "test_main.test_sub", Status: TestFailed, DurationMS: 2, Message: "assert -1 == 1\n +  where -1 = sub(1, 2)\ndef test_sub():\n>       assert sub(1, 2) == 1\nE       assert -1 == 1\nE        +  where -1 = sub(1, 2)\n\ntest_main.py:8: AssertionError"},
+				{Name: "test_main.test_div", Status: TestSkipped, Message: "not implemented yet"},
+				{Name: "test_main.test_db", Status: TestFailed, Message: "failed on setup with \"file /app/test_main.py, line 14\"\nfile /app/test_main.py, line 14\n  def test_db(db):\nE       fixture 'db' not found"},
+			},
+		},
+		{
+			name:   "nested suites",
+			format: runner.ReportJUnit,
+			report: `<testsuites><testsuite name="outer"><testsuite name="inner"><testcase name="deep" time="0.5"/></testsuite></testsuite><testcase name="shallow"/></testsuites>`,
+			want: []Test{
+				{Name: "deep", Status: TestPassed, DurationMS: 500},
+				{Name: "shallow", Status: TestPassed},
+			},
+		},
+		{
+			name:    "truncated junit",
+			format:  runner.ReportJUnit,
+			report:  pytest[:len(pytest)/2],
+			wantErr: true,
+		},
+		{
+			name:   "node tap",
+			format: runner.ReportTAP,
+			report: tap,
+			want: []Test{
+				{Name: "add", Status: TestPassed, DurationMS: 0.512},
+				{Name: "math > sub", Status: TestFailed, DurationMS: 0.75, Message: "Expected values to be strictly equal:\n-1 !== 1"},
+				{Name: "math > mul", Status: TestPassed, DurationMS: 0.25},
+				{Name: "div", Status: TestSkipped, DurationMS: 0.125},
+				{Name: "pow", Status: TestSkipped, DurationMS: 0.5},
+				{Name: "parse", Status: TestFailed, DurationMS: 2, Message: "Unexpected token } in JSON at position 1"},
+			},
+		},
+		{
+			name:   "truncated tap",
+			format: runner.ReportTAP,
+			report: tap[:strings.Index(tap, "# Subtest: div")],
+			want: []Test{
+				{Name: "add", Status: TestPassed, DurationMS: 0.512},
+				{Name: "math > sub", Status: TestFailed, DurationMS: 0.75, Message: "Expected values to be strictly equal:\n-1 !== 1"},
+				{Name: "math > mul", Status: TestPassed, DurationMS: 0.25},
+			},
+		},
+		{
+			name:   "truncated tap before the parent",
+			format: runner.ReportTAP,
+			report: tap[:strings.Index(tap, "not ok 2 - math")],
+			want: []Test{
+				{Name: "add", Status: TestPassed, DurationMS: 0.512},
+				{Name: "sub", Status: TestFailed, DurationMS: 0.75, Message: "Expected values to be strictly equal:\n-1 !== 1"},
+				{Name: "mul", Status: TestPassed, DurationMS: 0.25},
+			},
+		},
+		{
+			name:   "go test",
+			format: runner.ReportGoJSON,
+			report: goJSON,
+			want: []Test{
+				{Name: "TestAdd", Status: TestPassed},
+				{Name: "TestSub", Status: TestFailed, DurationMS: 250, Message: "=== RUN   TestSub\n    main_test.go:12: sub(1, 2) = -1, want 1\n--- FAIL: TestSub (0.25s)"},
+				{Name: "TestDiv", Status: TestSkipped},
+				{Name: "TestParse", Status: TestFailed, Message: "=== RUN   TestParse\n--- FAIL: TestParse (0.00s)\npanic: runtime error: index out of range [1] with length 1 [recovered]\n\tpanic: runtime error: index out of range [1] with length 1"},
+			},
+		},
+		{
+			name:   "truncated go test",
+			format: runner.ReportGoJSON,
+			report: goJSON[:strings.Index(goJSON, `"Action":"fail","Package":"main","Test":"TestSub"`)+10],
+			want: []Test{
+				{Name: "TestAdd", Status: TestPassed},
+			},
+		},
+		{
+			name:   "go test build failure",
+			format: runner.ReportGoJSON,
+			report: `{"Action":"start","Package":"main"}
+{"Action":"output","Package":"main","Output":"# main [main.test]\n"}
+{"Action":"output","Package":"main","Output":"./main_test.go:5:2: undefined: sub\n"}
+{"Action":"output","Package":"main","Output":"FAIL\tmain [build failed]\n"}
+{"Action":"fail","Package":"main","Elapsed":0}
+`,
+		},
+		{
+			name:   "rspec",
+			format: runner.ReportRSpec,
+			report: rspec,
+			want: []Test{
+				{Name: "Calc adds", Status: TestPassed, DurationMS: 250},
+				{Name: "Calc subtracts", Status: TestFailed, DurationMS: 500, Message: "RSpec::Expectations::ExpectationNotMetError: \nexpected: 1\n     got: -1\n\n(compared using ==)"},
+				{Name: "Calc divides", Status: TestSkipped, DurationMS: 125, Message: "Temporarily skipped with xit"},
+				{Name: "Calc parses", Status: TestFailed, Message: "NoMethodError: undefined method `parse' for an instance of Calc"},
+			},
+		},
+		{
+			name:    "truncated rspec",
+			format:  runner.ReportRSpec,
+			report:  rspec[:len(rspec)-100],
+			wantErr: true,
+		},
+		{
+			name:    "unknown format",
+			format:  "xunit",
+			report:  pytest,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parse(tt.format, tt.report)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got tests %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 0 || len(tt.want) != 0 {
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("got tests\n%+v\nwant\n%+v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// completeLines counts the lines of report that contain substr and were not
+// cut off.
+func completeLines(report, substr string) int {
+	lines := strings.Split(report, "\n")
+	n := 0
+	for _, line := range lines[:len(lines)-1] {
+		if strings.Contains(line, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestParseReportCap(t *testing.T) {
+	var tap strings.Builder
+	tap.WriteString("TAP version 13\n")
+	for i := 1; tap.Len() <= reportCap; i++ {
+		fmt.Fprintf(&tap, "# Subtest: test %d\nok %d - test %d\n  ---\n  duration_ms: 1\n  ...\n", i, i, i)
+	}
+
+	var goJSON strings.Builder
+	for i := 1; goJSON.Len() <= reportCap; i++ {
+		fmt.Fprintf(&goJSON, `{"Action":"pass","Package":"main","Test":"Test%d","Elapsed":0.001}`+"\n", i)
+	}
+
+	var junit strings.Builder
+	junit.WriteString(`<testsuites><testsuite name="pytest">`)
+	for i := 1; junit.Len() <= reportCap; i++ {
+		fmt.Fprintf(&junit, `<testcase classname="test_main" name="test_%d" time="0.001"/>`, i)
+	}
+	junit.WriteString(`</testsuite></testsuites>`)
+
+	var rspec strings.Builder
+	rspec.WriteString(`{"version":"3.13.0","examples":[`)
+	for i := 1; rspec.Len() <= reportCap; i++ {
+		fmt.Fprintf(&rspec, `{"full_description":"Calc %d","status":"passed","run_time":0.001},`, i)
+	}
+	rspec.WriteString(`{"full_description":"Calc","status":"passed","run_time":0.001}]}`)
+
+	// The runner reads no more than reportCap bytes, so a longer report
+	// ends in the middle of a line.
+	t.Run("tap", func(t *testing.T) {
+		report := tap.String()[:reportCap]
+		got, _ := parse(runner.ReportTAP, report)
+		if want := completeLines(report, "ok "); len(got) != want {
+			t.Fatalf("got %d tests, want %d", len(got), want)
+		}
+	})
+	t.Run("go test", func(t *testing.T) {
+		report := goJSON.String()[:reportCap]
+		got, _ := parse(runner.ReportGoJSON, report)
+		if want := completeLines(report, `"Action":"pass"`); len(got) != want {
+			t.Fatalf("got %d tests, want %d", len(got), want)
+		}
+	})
+	for _, tt := range []struct {
+		name, format, report string
+	}{
+		{"junit", runner.ReportJUnit, junit.String()[:reportCap]},
+		{"rspec", runner.ReportRSpec, rspec.String()[:reportCap]},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := parse(tt.format, tt.report); err == nil {
+				t.Fatalf("got %d tests from a cut off document, want an error", len(got))
+			}
+		})
+	}
+
+	// A single line longer than the scanner's buffer ends the TAP report.
+	t.Run("tap long line", func(t *testing.T) {
+		report := "TAP version 13\nok 1 - first\n# " + strings.Repeat("x", reportCap) + "\nok 2 - second\n"
+		got, _ := parse(runner.ReportTAP, report)
+		want := []Test{{Name: "first", Status: TestPassed}}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got tests %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestParseMessageCap(t *testing.T) {
+	long := strings.Repeat("é", reportCap)
+
+	var goJSON strings.Builder
+	for range 100 {
+		fmt.Fprintf(&goJSON, `{"Action":"output","Test":"TestBig","Output":%q}`+"\n", strings.Repeat("x", 1000)+"\n")
+	}
+	goJSON.WriteString(`{"Action":"fail","Test":"TestBig"}` + "\n")
+
+	tests := []struct {
+		name   string
+		format string
+		report string
+	}{
+		{"junit", runner.ReportJUnit, `<testsuite><testcase name="big"><failure message="` + long + `"/></testcase></testsuite>`},
+		{"tap", runner.ReportTAP, "not ok 1 - big\n  ---\n  error: |-\n" + strings.Repeat("    "+strings.Repeat("é", 100)+"\n", 100) + "  ...\n"},
+		{"go test", runner.ReportGoJSON, goJSON.String()},
+		{"rspec", runner.ReportRSpec, `{"examples":[{"full_description":"big","status":"failed","exception":{"class":"RuntimeError","message":"` + long + `"}}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parse(tt.format, tt.report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 {
+				t.Fatalf("got %d tests, want 1", len(got))
+			}
+			msg := got[0].Message
+			if len(msg) > maxMessageBytes+len("...") || !strings.HasSuffix(msg, "...") {
+				t.Fatalf("got a message of %d bytes ending in %q, want at most %d ending in \"...\"", len(msg), msg[max(len(msg)-8, 0):], maxMessageBytes)
+			}
+			if !utf8.ValidString(msg) {
+				t.Fatal("the message was cut in the middle of a rune")
+			}
+		})
+	}
+}
+
+// reportRunner returns a fixed run.
+type reportRunner struct {
+	run runner.ExecuteResult
+}
+
+func (r *reportRunner) Run(ctx context.Context, req runner.ExecuteRequest) (*runner.ExecuteResult, error) {
+	run := r.run
+	return &run, nil
+}
+
+func TestTesterRun(t *testing.T) {
+	pytest := readReport(t, "pytest.xml")
+	lang := runner.Language{ID: "python", Test: &runner.TestFramework{Format: runner.ReportJUnit}}
+
+	tests := []struct {
+		name   string
+		run    runner.ExecuteResult
+		want   Status
+		counts [3]int
+	}{
+		{"failing tests", runner.ExecuteResult{Status: runner.StatusRuntimeError, Report: pytest}, StatusFailed, [3]int{1, 2, 1}},
+		{"truncated report", runner.ExecuteResult{Status: runner.StatusRuntimeError, Report: pytest[:len(pytest)/2]}, StatusError, [3]int{}},
+		{"no report", runner.ExecuteResult{Status: runner.StatusOK}, StatusError, [3]int{}},
+		{"timed out", runner.ExecuteResult{Status: runner.StatusTimeout, Report: pytest}, StatusError, [3]int{}},
+		{"passing tests", runner.ExecuteResult{Status: runner.StatusOK, Report: `<testsuite><testcase name="a"/><testcase name="b"><skipped/></testcase></testsuite>`}, StatusPassed, [3]int{1, 0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := New(&reportRunner{run: tt.run}).Run(context.Background(), lang, runner.ExecuteRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Status != tt.want {
+				t.Fatalf("got status %q, want %q", res.Status, tt.want)
+			}
+			if got := [3]int{res.Passed, res.Failed, res.Skipped}; got != tt.counts {
+				t.Fatalf("got passed, failed, skipped %v, want %v", got, tt.counts)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package testrun
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/VJ-2303/code-runner/internal/runner"
+)
+
+// Status sums up a test run.
+type Status string
+
+const (
+	StatusPassed Status = "passed"
+	StatusFailed Status = "failed"
+	// StatusError means no test results could be read, because the code
+	// or the tests did not build, the run timed out or the framework
+	// wrote no report.
+	StatusError Status = "error"
+)
+
+// TestStatus is the outcome of a single test.
+type TestStatus string
+
+const (
+	TestPassed  TestStatus = "passed"
+	TestFailed  TestStatus = "failed"
+	TestSkipped TestStatus = "skipped"
+)
+
+type Test struct {
+	Name       string     `json:"name"`
+	Status     TestStatus `json:"status"`
+	DurationMS float64    `json:"duration_ms"`
+	Message    string     `json:"message,omitempty"`
+}
+
+type Result struct {
+	Status  Status `json:"status"`
+	Passed  int    `json:"passed"`
+	Failed  int    `json:"failed"`
+	Skipped int    `json:"skipped"`
+	Tests   []Test `json:"tests"`
+	// Run is the framework's run itself, with its console output.
+	Run *runner.ExecuteResult `json:"run"`
+}
+
+// Tester runs test files through a Runner and parses the reports of the
+// frameworks they use.
+type Tester struct {
+	runner runner.Runner
+}
+
+func New(r runner.Runner) *Tester {
+	return &Tester{runner: r}
+}
+
+// Run executes req, which must carry TestCode, and parses the report in the
+// format of lang's test framework.
+func (t *Tester) Run(ctx context.Context, lang runner.Language, req runner.ExecuteRequest) (*Result, error) {
+	if lang.Test == nil {
+		return nil, fmt.Errorf("language %s has no test framework", lang.ID)
+	}
+
+	run, err := t.runner.Run(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Status: StatusError, Tests: []Test{}, Run: run}
+
+	// A failing test is a non-zero exit, every other status means the
+	// framework did not get to finish its report.
+	if run.Status != runner.StatusOK && run.Status != runner.StatusRuntimeError {
+		return result, nil
+	}
+
+	tests, err := parse(lang.Test.Format, run.Report)
+	if err != nil || len(tests) == 0 {
+		return result, nil
+	}
+
+	result.Tests = tests
+	for _, test := range tests {
+		switch test.Status {
+		case TestPassed:
+			result.Passed++
+		case TestFailed:
+			result.Failed++
+		case TestSkipped:
+			result.Skipped++
+		}
+	}
+	result.Status = StatusPassed
+	if result.Failed > 0 {
+		result.Status = StatusFailed
+	}
+	return result, nil
+}
+
+func parse(format, report string) ([]Test, error) {
+	switch format {
+	case runner.ReportJUnit:
+		return parseJUnit(report)
+	case runner.ReportTAP:
+		return parseTAP(report), nil
+	case runner.ReportGoJSON:
+		return parseGoJSON(report), nil
+	case runner.ReportRSpec:
+		return parseRSpec(report)
+	}
+	return nil, fmt.Errorf("unknown report format %q", format)
+}
@@ -0,0 +1,2 @@
+ALTER TABLE snippets DROP COLUMN IF EXISTS entry_point;
+ALTER TABLE snippets DROP COLUMN IF EXISTS files;
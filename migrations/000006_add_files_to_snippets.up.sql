@@ -0,0 +1,2 @@
+ALTER TABLE snippets ADD COLUMN files jsonb NOT NULL DEFAULT '[]'::jsonb;
+ALTER TABLE snippets ADD COLUMN entry_point text NOT NULL DEFAULT '';
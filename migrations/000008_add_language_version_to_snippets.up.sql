@@ -0,0 +1 @@
+ALTER TABLE snippets ADD COLUMN language_version text NOT NULL DEFAULT '';